@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// AssetCacheControl is sent with fingerprinted assets. Their URLs change
+// whenever their content does, so they can be cached for a year.
+const AssetCacheControl = "public, max-age=31536000, immutable"
+
+// Assets serves files at content addressed paths, such as app.3f9a1c2b.js for
+// app.js, so that they can be cached indefinitely.
+type Assets struct {
+	fs       fs.FS
+	prefix   string
+	manifest map[string]string
+	files    map[string]string
+}
+
+// NewAssets fingerprints every file in fsys. Use os.DirFS for a directory on disk.
+func NewAssets(fsys fs.FS) (*Assets, error) {
+	manifest := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Precompressed siblings are served in place of the original by the file server.
+		if d.IsDir() || strings.HasSuffix(name, ".gz") {
+			return nil
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		manifest[name] = fingerprint(name, hex.EncodeToString(h.Sum(nil))[:8])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newAssets(fsys, manifest), nil
+}
+
+// LoadAssets creates Assets from a manifest written by WriteManifest, so that
+// fingerprinting can be done at build time rather than on startup.
+func LoadAssets(fsys fs.FS, manifest io.Reader) (*Assets, error) {
+	m := make(map[string]string)
+	if err := json.NewDecoder(manifest).Decode(&m); err != nil {
+		return nil, fmt.Errorf("engine: invalid asset manifest: %v", err)
+	}
+	return newAssets(fsys, m), nil
+}
+
+func newAssets(fsys fs.FS, manifest map[string]string) *Assets {
+	a := &Assets{fs: fsys, manifest: manifest, files: make(map[string]string, len(manifest))}
+	for name, hashed := range manifest {
+		a.files[hashed] = name
+	}
+	return a
+}
+
+// fingerprint inserts hash before the extension of name.
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// WriteManifest writes the mapping of asset names to fingerprinted names as JSON.
+func (a *Assets) WriteManifest(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a.manifest)
+}
+
+// Manifest returns the mapping of asset names to fingerprinted names.
+func (a *Assets) Manifest() map[string]string {
+	m := make(map[string]string, len(a.manifest))
+	for k, v := range a.manifest {
+		m[k] = v
+	}
+	return m
+}
+
+// AssetURL returns the fingerprinted URL of the named asset. Unknown assets
+// are returned unchanged relative to the mount path.
+func (a *Assets) AssetURL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if hashed, ok := a.manifest[name]; ok {
+		name = hashed
+	}
+	return path.Join(a.prefix, name)
+}
+
+// FuncMap returns template functions exposing AssetURL to html/template.
+func (a *Assets) FuncMap() template.FuncMap {
+	return template.FuncMap{"AssetURL": a.AssetURL}
+}
+
+type assetServer struct {
+	files map[string]string
+	*fileServer
+}
+
+func (s *assetServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	name, ok := s.files[strings.TrimPrefix(GetContext(req).Params.ByName("filepath"), "/")]
+	if !ok {
+		s.notFound().ServeHTTP(rw, req)
+		return
+	}
+	s.serveFile(rw, req, "/"+name)
+}
+
+// Assets serves the fingerprinted files of a at relativePath.
+func (r *Router) Assets(relativePath string, a *Assets) {
+	absolutePath := r.calculateAbsolutePath(relativePath)
+	a.prefix = absolutePath
+	handler := &assetServer{
+		files: a.files,
+		fileServer: &fileServer{
+			root:     http.FS(a.fs),
+			config:   StaticConfig{CacheControl: AssetCacheControl, Precompressed: true},
+			notFound: r.notFoundHandler,
+		},
+	}
+	absolutePath = path.Join(absolutePath, "/*filepath")
+	r.Handle("GET", absolutePath, handler).Hidden()
+	r.Handle("HEAD", absolutePath, handler).Hidden()
+}
@@ -0,0 +1,82 @@
+package engine_test
+
+import (
+	"bytes"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func assetsFS() fstest.MapFS {
+	return fstest.MapFS{
+		"css/site.css":    {Data: []byte("body{}")},
+		"css/site.css.gz": {Data: []byte("gzipped css")},
+		"app.js":          {Data: []byte("console.log(1)")},
+	}
+}
+
+func TestAssets(t *testing.T) {
+	assets, err := engine.NewAssets(assetsFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := engine.NewRouter()
+	r.Assets("/assets", assets)
+	c := enginetest.New(t, r)
+
+	manifest := assets.Manifest()
+	if len(manifest) != 2 {
+		t.Fatalf("manifest = %v, want 2 assets", manifest)
+	}
+	url := assets.AssetURL("/css/site.css")
+	if !regexp.MustCompile(`^/assets/css/site\.[0-9a-f]{8}\.css$`).MatchString(url) {
+		t.Errorf("AssetURL = %q", url)
+	}
+	c.Get(url).Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Cache-Control", engine.AssetCacheControl).
+		AssertBody("body{}")
+	c.Get(url).Header("Accept-Encoding", "gzip").Do().
+		AssertHeader("Content-Encoding", "gzip").
+		AssertBody("gzipped css")
+	c.Get("/assets/css/site.css").Do().AssertStatus(http.StatusNotFound)
+	if got := assets.AssetURL("missing.png"); got != "/assets/missing.png" {
+		t.Errorf("AssetURL(missing.png) = %q", got)
+	}
+
+	var out bytes.Buffer
+	tmpl := template.Must(template.New("page").Funcs(assets.FuncMap()).Parse(`<script src="{{AssetURL "app.js"}}"></script>`))
+	if err := tmpl.Execute(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<script src="` + assets.AssetURL("app.js") + `"></script>`; out.String() != want {
+		t.Errorf("template = %q, want %q", out.String(), want)
+	}
+}
+
+func TestAssetsManifest(t *testing.T) {
+	built, err := engine.NewAssets(assetsFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest bytes.Buffer
+	if err := built.WriteManifest(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := engine.LoadAssets(assetsFS(), &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := engine.NewRouter()
+	r.Assets("/assets", loaded)
+	enginetest.New(t, r).Get(loaded.AssetURL("app.js")).Do().AssertStatus(http.StatusOK).AssertBody("console.log(1)")
+
+	if _, err := engine.LoadAssets(assetsFS(), strings.NewReader("not json")); err == nil {
+		t.Error("LoadAssets with an invalid manifest succeeded")
+	}
+}
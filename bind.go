@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// bindSource is somewhere in a request that struct fields can be bound from.
+type bindSource struct {
+	tag    string
+	name   string
+	lookup func(key string) ([]string, bool)
+}
+
+func valuesSource(tag string, values url.Values) bindSource {
+	return bindSource{tag: tag, name: tag, lookup: func(key string) ([]string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}}
+}
+
+func querySource(req *http.Request) bindSource {
+	return valuesSource("query", req.URL.Query())
+}
+
+func headerSource(req *http.Request) bindSource {
+	return bindSource{tag: "header", name: "header", lookup: func(key string) ([]string, bool) {
+		v, ok := req.Header[http.CanonicalHeaderKey(key)]
+		return v, ok
+	}}
+}
+
+func paramSource(req *http.Request) bindSource {
+	params := GetContext(req).Params
+	return bindSource{tag: "param", name: "path", lookup: func(key string) ([]string, bool) {
+		for _, p := range params {
+			if p.Key == key {
+				return []string{p.Value}, true
+			}
+		}
+		return nil, false
+	}}
+}
+
+// BindQuery fills the fields of dst, a pointer to a struct, tagged with
+// `query:"name"` from the request's query string and then validates it.
+//
+// Fields may be strings, bools, numbers, time.Time, time.Duration, types
+// implementing encoding.TextUnmarshaler, slices of these, or pointers to them
+// for optional values. Slices are filled from repeated or comma separated
+// values. A `default:"value"` tag is used when no source has the value and
+// the field is still zero, and a `time_format:"2006-01-02"` tag overrides the
+// default RFC 3339 layout. Embedded structs are filled too. Errors are
+// ValidationErrors.
+func BindQuery(req *http.Request, dst interface{}) error {
+	return bindAndValidate(dst, querySource(req))
+}
+
+// BindHeaders fills the fields of dst tagged with `header:"Name"` from the
+// request's headers and then validates it, as BindQuery does.
+func BindHeaders(req *http.Request, dst interface{}) error {
+	return bindAndValidate(dst, headerSource(req))
+}
+
+// BindJSON decodes the request's JSON body into dst and then validates it.
+// Errors are ValidationErrors.
+func BindJSON(req *http.Request, dst interface{}) error {
+	return validateAfter(dst, decodeJSON(req, dst))
+}
+
+// Bind fills dst from the request's path params (`param:"name"`), query
+// string, headers and JSON body, then validates it. All errors are reported
+// together as ValidationErrors.
+func Bind(req *http.Request, dst interface{}) error {
+	var errs ValidationErrors
+	if hasBody(req) {
+		errs = append(errs, decodeJSON(req, dst)...)
+	}
+	errs = append(errs, bind(dst, paramSource(req), querySource(req), headerSource(req))...)
+	return validateAfter(dst, errs)
+}
+
+func hasBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 {
+		return false
+	}
+	ct := req.Header.Get("Content-Type")
+	return ct == "" || strings.HasPrefix(ct, "application/json") || strings.HasSuffix(strings.SplitN(ct, ";", 2)[0], "+json")
+}
+
+func decodeJSON(req *http.Request, dst interface{}) ValidationErrors {
+	err := json.NewDecoder(req.Body).Decode(dst)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *json.UnmarshalTypeError:
+		return ValidationErrors{{Field: e.Field, Source: "body", Message: "must be " + jsonKind(e.Type)}}
+	default:
+		if err == io.EOF {
+			return nil
+		}
+		return ValidationErrors{{Source: "body", Message: "Invalid JSON: " + err.Error()}}
+	}
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+func bindAndValidate(dst interface{}, sources ...bindSource) error {
+	return validateAfter(dst, bind(dst, sources...))
+}
+
+// validateAfter validates dst and merges the result with the errors from
+// binding it. Fields that failed to bind aren't validated.
+func validateAfter(dst interface{}, errs ValidationErrors) error {
+	failed := make(map[FieldError]bool, len(errs))
+	for _, fe := range errs {
+		failed[FieldError{Field: fe.Field, Source: fe.Source}] = true
+	}
+	if verrs, ok := Validate(dst).(ValidationErrors); ok {
+		for _, fe := range verrs {
+			if !failed[FieldError{Field: fe.Field, Source: fe.Source}] {
+				errs = append(errs, fe)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func bind(dst interface{}, sources ...bindSource) ValidationErrors {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		panic("engine: bind destination must be a pointer to a struct")
+	}
+	return bindStruct(rv.Elem(), sources)
+}
+
+func bindStruct(rv reflect.Value, sources []bindSource) (errs ValidationErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+		if sf.Anonymous && indirectType(sf.Type).Kind() == reflect.Struct {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					if !fv.CanSet() {
+						continue
+					}
+					fv.Set(reflect.New(sf.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			errs = append(errs, bindStruct(fv, sources)...)
+			continue
+		}
+		if sf.PkgPath != "" || sf.Type == uploadedFileType || sf.Type == uploadedFilesType {
+			continue
+		}
+		// The default applies once every source has been tried, and only if
+		// none of them, nor the JSON body, set the field.
+		var first *FieldError
+		bound := false
+		for _, src := range sources {
+			name := tagName(sf.Tag.Get(src.tag))
+			if name == "" || name == "-" {
+				continue
+			}
+			if first == nil {
+				first = &FieldError{Field: name, Source: src.name}
+			}
+			values, ok := src.lookup(name)
+			if !ok || len(values) == 0 {
+				continue
+			}
+			bound = true
+			if err := setField(fv, values, sf.Tag.Get("time_format")); err != nil {
+				errs = append(errs, FieldError{Field: name, Source: src.name, Message: err.Error()})
+			}
+		}
+		def, hasDefault := sf.Tag.Lookup("default")
+		if first == nil || bound || !hasDefault || !fv.IsZero() {
+			continue
+		}
+		if err := setField(fv, []string{def}, sf.Tag.Get("time_format")); err != nil {
+			first.Message = err.Error()
+			errs = append(errs, *first)
+		}
+	}
+	return errs
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+func setField(fv reflect.Value, values []string, timeFormat string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		var parts []string
+		for _, v := range values {
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					parts = append(parts, p)
+				}
+			}
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(parts), len(parts))
+		for i, p := range parts {
+			if err := setValue(slice.Index(i), p, timeFormat); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setValue(fv, values[0], timeFormat)
+}
+
+func setValue(fv reflect.Value, s string, timeFormat string) error {
+	if fv.Kind() == reflect.Ptr {
+		v := reflect.New(fv.Type().Elem())
+		if err := setValue(v.Elem(), s, timeFormat); err != nil {
+			return err
+		}
+		fv.Set(v)
+		return nil
+	}
+
+	switch fv.Type() {
+	case timeType:
+		if timeFormat == "" {
+			timeFormat = time.RFC3339
+		}
+		t, err := time.Parse(timeFormat, s)
+		if err != nil {
+			return fmt.Errorf("must be a time formatted as %s", timeFormat)
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 1m30s")
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("is invalid: %v", err)
+		}
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		fv.SetFloat(n)
+	default:
+		panic(fmt.Sprintf("engine: can't bind to a field of type %s", fv.Type()))
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type SearchRequest struct {
+	Query  string        `query:"q"`
+	Tags   []string      `query:"tag"`
+	Since  time.Time     `query:"since" time_format:"2006-01-02"`
+	Within time.Duration `query:"within"`
+	Draft  *bool         `query:"draft"`
+	Limit  int           `query:"limit" default:"20"`
+}
+
+func TestBindQuery(t *testing.T) {
+	bind := func(target string) (SearchRequest, error) {
+		var in SearchRequest
+		err := engine.BindQuery(enginetest.New(t, nil).Get(target).Build(), &in)
+		return in, err
+	}
+
+	in, err := bind("/search?q=go&tag=a,b&tag=c&since=2024-02-01&within=1h&draft=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SearchRequest{
+		Query:  "go",
+		Tags:   []string{"a", "b", "c"},
+		Since:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		Within: time.Hour,
+		Draft:  new(bool),
+		Limit:  20,
+	}
+	if !reflect.DeepEqual(in, want) {
+		t.Errorf("BindQuery = %+v, want %+v", in, want)
+	}
+
+	_, err = bind("/search?limit=x&since=yesterday")
+	want2 := engine.ValidationErrors{
+		{Field: "since", Source: "query", Message: "must be a time formatted as 2006-01-02"},
+		{Field: "limit", Source: "query", Message: "must be an integer"},
+	}
+	if !reflect.DeepEqual(err, want2) {
+		t.Errorf("BindQuery errors = %v, want %v", err, want2)
+	}
+}
+
+type ListRequest struct {
+	Tenant string `query:"tenant" header:"X-Tenant" default:"public"`
+	Limit  int    `json:"limit" query:"limit" default:"10"`
+	Sort   string `json:"sort" param:"sort" default:"name"`
+	Broken int    `query:"broken" default:"lots"`
+}
+
+func TestBindDefaults(t *testing.T) {
+	var got ListRequest
+	var err error
+	r := engine.NewRouter()
+	r.Post("/items", func(rw http.ResponseWriter, req *http.Request) {
+		got = ListRequest{}
+		err = engine.Bind(req, &got)
+	})
+	c := enginetest.New(t, r)
+
+	c.Post("/items").Do()
+	if got.Tenant != "public" || got.Limit != 10 || got.Sort != "name" {
+		t.Errorf("Bind without values = %+v, want the defaults", got)
+	}
+	if want := (engine.ValidationErrors{{Field: "broken", Source: "query", Message: "must be an integer"}}); !reflect.DeepEqual(err, want) {
+		t.Errorf("Bind errors = %v, want %v", err, want)
+	}
+
+	// Values from any source, including the JSON body, win over defaults.
+	c.Post("/items?tenant=acme&broken=1").Body("application/json", strings.NewReader(`{"limit": 50, "sort": "date"}`)).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Tenant != "acme" || got.Limit != 50 || got.Sort != "date" {
+		t.Errorf("Bind = %+v, want acme, 50 and date", got)
+	}
+	c.Post("/items?broken=1").Header("X-Tenant", "globex").Do()
+	if got.Tenant != "globex" {
+		t.Errorf("Tenant = %q, want globex", got.Tenant)
+	}
+}
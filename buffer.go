@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// responseBuffer captures a handler's response so it can be inspected before
+// being sent. Once the body grows past limit the buffered response is written
+// to rw and later writes go straight through.
+type responseBuffer struct {
+	rw          http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	limit       int
+	passthrough bool
+}
+
+func newResponseBuffer(rw http.ResponseWriter, limit int) *responseBuffer {
+	return &responseBuffer{rw: rw, header: make(http.Header), limit: limit}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	if b.passthrough {
+		return b.rw.Header()
+	}
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(statusCode int) {
+	if b.status != 0 {
+		return
+	}
+	b.status = statusCode
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.passthrough {
+		return b.rw.Write(data)
+	}
+	if b.limit > 0 && b.body.Len()+len(data) > b.limit {
+		b.flush()
+		return b.rw.Write(data)
+	}
+	return b.body.Write(data)
+}
+
+// Status returns the buffered status code, defaulting to 200.
+func (b *responseBuffer) Status() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+	return b.status
+}
+
+// flush writes the buffered response to rw and switches to passthrough.
+func (b *responseBuffer) flush() {
+	if b.passthrough {
+		return
+	}
+	b.passthrough = true
+	copyHeader(b.rw.Header(), b.header)
+	b.rw.WriteHeader(b.Status())
+	b.rw.Write(b.body.Bytes())
+	b.body.Reset()
+}
+
+// Flush gives up buffering, since the handler wants the response sent now.
+func (b *responseBuffer) Flush() {
+	if b.rw == nil {
+		return
+	}
+	b.flush()
+	if flusher, ok := b.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+// isUpgrade reports whether req asks to switch protocols, as WebSocket
+// handshakes do. Their responses can't be buffered, since the handler hijacks
+// the connection.
+func isUpgrade(req *http.Request) bool {
+	return headerContainsToken(req.Header, "Connection", "upgrade")
+}
@@ -0,0 +1,431 @@
+package engine
+
+import (
+	"container/list"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cacheTagsCtxKey key = 1
+
+// CacheConfig configures a Cache.
+type CacheConfig struct {
+	// MaxEntries bounds the number of responses held. Defaults to 1000.
+	MaxEntries int
+	// MaxSize is the largest response body that is cached. Defaults to 1MB.
+	MaxSize int
+	// DefaultTTL is used for responses that don't set max-age. If zero such
+	// responses aren't cached.
+	DefaultTTL time.Duration
+}
+
+// Cache is an in-memory, LRU bounded HTTP response cache. Responses are keyed
+// by method, path, query and the request headers named in the response's Vary
+// header, and are stored according to the handler's Cache-Control header.
+// Requests with an Authorization or Cookie header only share responses marked
+// public or with s-maxage.
+type Cache struct {
+	config  CacheConfig
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	vary    map[string][]string
+	bases   map[string]int
+	flights map[string]*cacheFlight
+}
+
+type cacheEntry struct {
+	key        string
+	base       string
+	path       string
+	status     int
+	header     http.Header
+	body       []byte
+	tags       []string
+	shared     bool
+	stored     time.Time
+	expires    time.Time
+	staleUntil time.Time
+}
+
+type cacheFlight struct {
+	done  chan struct{}
+	entry *cacheEntry
+}
+
+// NewCache creates a Cache. Use Cache.Middleware to cache a route's responses.
+func NewCache(config *CacheConfig) *Cache {
+	c := &Cache{
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		vary:    make(map[string][]string),
+		bases:   make(map[string]int),
+		flights: make(map[string]*cacheFlight),
+	}
+	if config != nil {
+		c.config = *config
+	}
+	if c.config.MaxEntries == 0 {
+		c.config.MaxEntries = 1000
+	}
+	if c.config.MaxSize == 0 {
+		c.config.MaxSize = 1 << 20
+	}
+	return c
+}
+
+// CacheTag tags the response to req so that it can be purged with Cache.PurgeTag.
+func CacheTag(req *http.Request, tags ...string) {
+	ctx := GetContext(req)
+	existing, _ := ctx.Value(cacheTagsCtxKey).([]string)
+	ctx.Set(cacheTagsCtxKey, append(existing, tags...))
+}
+
+// Middleware serves GET and HEAD requests, other than upgrades such as
+// WebSocket handshakes, from the cache. Fresh entries are served directly.
+// Stale entries within their stale-while-revalidate window are served while a
+// single background request refreshes them. Concurrent misses for the same key
+// wait for the first to complete rather than all calling next.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" || isUpgrade(req) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		base := cacheBaseKey(req)
+		now := time.Now()
+		c.mu.Lock()
+		key := cacheKey(base, c.vary[base], req)
+		if credentialed(req) {
+			c.serveCredentialed(rw, req, next, base, key, now)
+			return
+		}
+		if e := c.get(key); e != nil {
+			if now.Before(e.expires) {
+				c.mu.Unlock()
+				e.writeTo(rw, req, "HIT")
+				return
+			}
+			if now.Before(e.staleUntil) {
+				if _, ok := c.flights[key]; !ok {
+					go c.refresh(next, cacheRequest(req), base, key, c.startFlight(key))
+				}
+				c.mu.Unlock()
+				e.writeTo(rw, req, "STALE")
+				return
+			}
+		}
+		if f, ok := c.flights[key]; ok {
+			c.mu.Unlock()
+			<-f.done
+			// A cold miss's flight is keyed before its Vary headers are
+			// known, so its response may be another variant.
+			if e := f.entry; e != nil && cacheKey(base, cacheVary(e.key), req) == e.key {
+				e.writeTo(rw, req, "HIT")
+				return
+			}
+			next.ServeHTTP(rw, req)
+			return
+		}
+		f := c.startFlight(key)
+		c.mu.Unlock()
+
+		var e *cacheEntry
+		defer func() { c.finish(key, f, e) }()
+		buf := newResponseBuffer(rw, c.config.MaxSize)
+		next.ServeHTTP(buf, req)
+		if buf.passthrough {
+			return
+		}
+		e = c.newEntry(buf, req, base)
+		buf.header.Set("X-Cache", "MISS")
+		buf.flush()
+	})
+}
+
+// serveCredentialed serves requests with credentials, which may get
+// personalized responses. They're only served from and stored in the cache
+// when responses are explicitly shared, with public or s-maxage. c.mu is held
+// and is released.
+func (c *Cache) serveCredentialed(rw http.ResponseWriter, req *http.Request, next http.Handler, base, key string, now time.Time) {
+	if e := c.get(key); e != nil && e.shared && now.Before(e.expires) {
+		c.mu.Unlock()
+		e.writeTo(rw, req, "HIT")
+		return
+	}
+	c.mu.Unlock()
+	buf := newResponseBuffer(rw, c.config.MaxSize)
+	next.ServeHTTP(buf, req)
+	if buf.passthrough {
+		return
+	}
+	if e := c.newEntry(buf, req, base); e != nil {
+		c.mu.Lock()
+		c.put(e)
+		c.vary[e.base] = cacheVary(e.key)
+		c.mu.Unlock()
+	}
+	buf.header.Set("X-Cache", "BYPASS")
+	buf.flush()
+}
+
+// credentialed reports whether the request carries credentials.
+func credentialed(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+// refresh revalidates a stale entry in the background.
+func (c *Cache) refresh(next http.Handler, req *http.Request, base, key string, f *cacheFlight) {
+	var e *cacheEntry
+	defer func() {
+		if err := recover(); err != nil {
+			log.WithField("path", req.URL.Path).Errorf("cache refresh panicked: %v", err)
+		}
+		c.finish(key, f, e)
+	}()
+	buf := newResponseBuffer(nil, 0)
+	next.ServeHTTP(buf, req)
+	if buf.body.Len() <= c.config.MaxSize {
+		e = c.newEntry(buf, req, base)
+	}
+}
+
+// cacheRequest copies req, with its own Context, so it can be served after
+// the original request has completed.
+func cacheRequest(req *http.Request) *http.Request {
+	r := req.WithContext(context.Background())
+	r.Body = http.NoBody
+	r.Header = make(http.Header)
+	copyHeader(r.Header, req.Header)
+	GetContext(r).Params = GetContext(req).Params
+	return r
+}
+
+// newEntry returns an entry for the buffered response, or nil if it can't be stored.
+func (c *Cache) newEntry(buf *responseBuffer, req *http.Request, base string) *cacheEntry {
+	switch buf.Status() {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return nil
+	}
+	if _, ok := buf.header["Set-Cookie"]; ok {
+		return nil
+	}
+	cc := parseCacheControl(buf.header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return nil
+	}
+	if _, ok := cc["private"]; ok {
+		return nil
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return nil
+	}
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	shared := public || sMaxAge
+	if credentialed(req) && !shared {
+		return nil
+	}
+	ttl := c.config.DefaultTTL
+	if age, ok := cc.seconds("s-maxage"); ok {
+		ttl = age
+	} else if age, ok := cc.seconds("max-age"); ok {
+		ttl = age
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	var vary []string
+	for _, v := range buf.header["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+
+	now := time.Now()
+	swr, _ := cc.seconds("stale-while-revalidate")
+	header := make(http.Header)
+	copyHeader(header, buf.header)
+	tags, _ := GetContext(req).Value(cacheTagsCtxKey).([]string)
+	return &cacheEntry{
+		key:        cacheKey(base, vary, req),
+		base:       base,
+		path:       req.URL.Path,
+		status:     buf.Status(),
+		header:     header,
+		body:       append([]byte(nil), buf.body.Bytes()...),
+		tags:       tags,
+		shared:     shared,
+		stored:     now,
+		expires:    now.Add(ttl),
+		staleUntil: now.Add(ttl + swr),
+	}
+}
+
+func (c *Cache) startFlight(key string) *cacheFlight {
+	f := &cacheFlight{done: make(chan struct{})}
+	c.flights[key] = f
+	return f
+}
+
+// finish stores e, if there is one, and releases requests waiting on f.
+func (c *Cache) finish(key string, f *cacheFlight, e *cacheEntry) {
+	c.mu.Lock()
+	delete(c.flights, key)
+	if e != nil {
+		c.put(e)
+		c.vary[e.base] = cacheVary(e.key)
+	}
+	c.mu.Unlock()
+	f.entry = e
+	close(f.done)
+}
+
+func (c *Cache) get(key string) *cacheEntry {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+func (c *Cache) put(e *cacheEntry) {
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bases[e.base]++
+	for c.lru.Len() > c.config.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	if c.bases[e.base]--; c.bases[e.base] == 0 {
+		delete(c.bases, e.base)
+		delete(c.vary, e.base)
+	}
+}
+
+// PurgePrefix removes all responses for paths starting with prefix and
+// returns the number removed.
+func (c *Cache) PurgePrefix(prefix string) int {
+	return c.purge(func(e *cacheEntry) bool {
+		return strings.HasPrefix(e.path, prefix)
+	})
+}
+
+// PurgeTag removes all responses tagged with tag by CacheTag and returns the
+// number removed.
+func (c *Cache) PurgeTag(tag string) int {
+	return c.purge(func(e *cacheEntry) bool {
+		for _, t := range e.tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Purge removes every response from the cache.
+func (c *Cache) Purge() int {
+	return c.purge(func(*cacheEntry) bool { return true })
+}
+
+func (c *Cache) purge(match func(*cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*cacheEntry)) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+func (e *cacheEntry) writeTo(rw http.ResponseWriter, req *http.Request, status string) {
+	copyHeader(rw.Header(), e.header)
+	rw.Header().Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+	rw.Header().Set("X-Cache", status)
+	rw.WriteHeader(e.status)
+	if req.Method != "HEAD" {
+		rw.Write(e.body)
+	}
+}
+
+func cacheBaseKey(req *http.Request) string {
+	return req.Method + " " + req.URL.Path + "?" + req.URL.Query().Encode()
+}
+
+// cacheKey appends the values of the vary headers to base. The header names
+// are kept in the key so they can be recovered by cacheVary.
+func cacheKey(base string, vary []string, req *http.Request) string {
+	key := base
+	for _, name := range vary {
+		key += "\n" + name + ":" + strings.Join(req.Header[name], ",")
+	}
+	return key
+}
+
+func cacheVary(key string) []string {
+	lines := strings.Split(key, "\n")[1:]
+	vary := make([]string, len(lines))
+	for i, line := range lines {
+		vary[i] = line[:strings.Index(line, ":")]
+	}
+	return vary
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(header string) cacheControl {
+	cc := cacheControl{}
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "" {
+			continue
+		}
+		if i := strings.Index(directive, "="); i >= 0 {
+			cc[directive[:i]] = strings.Trim(directive[i+1:], `"`)
+		} else {
+			cc[directive] = ""
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
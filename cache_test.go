@@ -0,0 +1,196 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counting returns a handler writing how often it has been called, with the
+// given Cache-Control header.
+func counting(calls *int32, cacheControl string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		if cacheControl != "" {
+			rw.Header().Set("Cache-Control", cacheControl)
+		}
+		rw.Write([]byte(strconv.Itoa(int(n))))
+	}
+}
+
+func TestCache(t *testing.T) {
+	var calls, uncached int32
+	cache := engine.NewCache(nil)
+	r := engine.NewRouter()
+	r.Use(cache.Middleware)
+	r.Get("/cached", counting(&calls, "max-age=60"))
+	r.Get("/uncached", counting(&uncached, ""))
+	r.Post("/cached", counting(&calls, "max-age=60"))
+	c := enginetest.New(t, r)
+
+	c.Get("/cached").Do().AssertHeader("X-Cache", "MISS").AssertBody("1")
+	c.Get("/cached").Do().AssertHeader("X-Cache", "HIT").AssertHeader("Age", "0").AssertBody("1")
+	c.Head("/cached").Do().AssertHeader("X-Cache", "MISS")
+	c.Get("/cached?page=2").Do().AssertHeader("X-Cache", "MISS").AssertBody("3")
+	c.Post("/cached").Do().AssertHeader("X-Cache", "").AssertBody("4")
+	c.Get("/uncached").Do().AssertBody("1")
+	c.Get("/uncached").Do().AssertBody("2")
+}
+
+func TestCacheLRU(t *testing.T) {
+	var calls int32
+	cache := engine.NewCache(&engine.CacheConfig{MaxEntries: 2})
+	r := engine.NewRouter()
+	r.Use(cache.Middleware)
+	r.Get("/:name", counting(&calls, "max-age=60"))
+	c := enginetest.New(t, r)
+
+	c.Get("/a").Do().AssertHeader("X-Cache", "MISS")
+	c.Get("/b").Do().AssertHeader("X-Cache", "MISS")
+	c.Get("/a").Do().AssertHeader("X-Cache", "HIT")
+	c.Get("/c").Do().AssertHeader("X-Cache", "MISS")
+	c.Get("/a").Do().AssertHeader("X-Cache", "HIT")
+	c.Get("/c").Do().AssertHeader("X-Cache", "HIT")
+	c.Get("/b").Do().AssertHeader("X-Cache", "MISS")
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	cache := engine.NewCache(nil)
+	r := engine.NewRouter()
+	r.Use(cache.Middleware)
+	r.Get("/doc", func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		rw.Header().Set("Cache-Control", "max-age=60")
+		rw.Header().Set("Vary", "Accept")
+		rw.Write([]byte(req.Header.Get("Accept")))
+	})
+	c := enginetest.New(t, r)
+
+	// Both requests miss, and the second waits on the first's flight, which
+	// turns out to be for another variant.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Get("/doc").Header("Accept", "application/json").Do().AssertBody("application/json")
+	}()
+	<-started
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	c.Get("/doc").Header("Accept", "application/xml").Do().AssertBody("application/xml")
+	<-done
+
+	c.Get("/doc").Header("Accept", "application/json").Do().AssertHeader("X-Cache", "HIT").AssertBody("application/json")
+	c.Get("/doc").Header("Accept", "text/csv").Do().AssertHeader("X-Cache", "MISS").AssertBody("text/csv")
+	c.Get("/doc").Header("Accept", "text/csv").Do().AssertHeader("X-Cache", "HIT").AssertBody("text/csv")
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("handler called %d times, want 3", n)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for an entry to go stale")
+	}
+	var calls int32
+	cache := engine.NewCache(nil)
+	r := engine.NewRouter()
+	r.Use(cache.Middleware)
+	r.Get("/doc", counting(&calls, "max-age=1, stale-while-revalidate=60"))
+	c := enginetest.New(t, r)
+
+	c.Get("/doc").Do().AssertHeader("X-Cache", "MISS").AssertBody("1")
+	time.Sleep(1100 * time.Millisecond)
+	c.Get("/doc").Do().AssertHeader("X-Cache", "STALE").AssertBody("1")
+	deadline := time.Now().Add(time.Second)
+	for {
+		res := c.Get("/doc").Do()
+		if res.Header().Get("X-Cache") == "HIT" && res.Body.String() == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entry not refreshed: %s %q", res.Header().Get("X-Cache"), res.Body.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	var calls int32
+	cache := engine.NewCache(nil)
+	r := engine.NewRouter()
+	r.Use(cache.Middleware)
+	r.Get("/users/:id", func(rw http.ResponseWriter, req *http.Request) {
+		engine.CacheTag(req, "user:"+engine.GetContext(req).Params.ByName("id"))
+		counting(&calls, "max-age=60")(rw, req)
+	})
+	r.Get("/posts/:id", counting(&calls, "max-age=60"))
+	c := enginetest.New(t, r)
+	for _, p := range []string{"/users/1", "/users/2", "/posts/1", "/posts/2"} {
+		c.Get(p).Do().AssertHeader("X-Cache", "MISS")
+	}
+
+	if n := cache.PurgeTag("user:1"); n != 1 {
+		t.Errorf("PurgeTag = %d, want 1", n)
+	}
+	c.Get("/users/1").Do().AssertHeader("X-Cache", "MISS")
+	c.Get("/users/2").Do().AssertHeader("X-Cache", "HIT")
+	if n := cache.PurgePrefix("/posts/"); n != 2 {
+		t.Errorf("PurgePrefix = %d, want 2", n)
+	}
+	c.Get("/posts/1").Do().AssertHeader("X-Cache", "MISS")
+	if n := cache.Purge(); n != 3 {
+		t.Errorf("Purge = %d, want 3", n)
+	}
+	c.Get("/users/2").Do().AssertHeader("X-Cache", "MISS")
+}
+
+func TestCacheCredentials(t *testing.T) {
+	var private, public int32
+	cache := engine.NewCache(&engine.CacheConfig{DefaultTTL: time.Minute})
+	r := engine.NewRouter()
+	r.Use(cache.Middleware)
+	r.Get("/me", counting(&private, ""))
+	r.Get("/news", counting(&public, "public, max-age=60"))
+	c := enginetest.New(t, r)
+
+	c.Get("/me").Header("Authorization", "Bearer alice").Do().AssertHeader("X-Cache", "BYPASS").AssertBody("1")
+	c.Get("/me").Header("Cookie", "session=bob").Do().AssertHeader("X-Cache", "BYPASS").AssertBody("2")
+	c.Get("/me").Do().AssertHeader("X-Cache", "MISS").AssertBody("3")
+	c.Get("/me").Do().AssertHeader("X-Cache", "HIT").AssertBody("3")
+	c.Get("/me").Header("Authorization", "Bearer alice").Do().AssertHeader("X-Cache", "BYPASS").AssertBody("4")
+
+	c.Get("/news").Header("Authorization", "Bearer alice").Do().AssertHeader("X-Cache", "BYPASS").AssertBody("1")
+	c.Get("/news").Header("Authorization", "Bearer bob").Do().AssertHeader("X-Cache", "HIT").AssertBody("1")
+	c.Get("/news").Do().AssertHeader("X-Cache", "HIT").AssertBody("1")
+}
+
+func TestCacheWebSocket(t *testing.T) {
+	cache := engine.NewCache(&engine.CacheConfig{DefaultTTL: time.Minute})
+	r := engine.NewRouter()
+	r.Use(cache.Middleware)
+	r.WebSocket("/ws", nil, func(c *engine.WebSocketConn) {
+		c.WriteMessage(engine.TextMessage, []byte("hi"))
+		c.ReadMessage()
+	})
+	for i := 0; i < 2; i++ {
+		c, res := dial(t, r, websocketRequest("/ws"))
+		if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("X-Cache") != "" {
+			t.Fatalf("status = %d, X-Cache = %q; want 101 without X-Cache", res.StatusCode, res.Header.Get("X-Cache"))
+		}
+		c.expectMessage(engine.TextMessage, "hi")
+	}
+}
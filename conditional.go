@@ -0,0 +1,163 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConditionalConfig configures ConditionalMiddleware.
+type ConditionalConfig struct {
+	// Weak generates weak ETags, for responses that are equivalent but may not
+	// be byte for byte identical, such as those that are later compressed.
+	Weak bool
+	// MaxSize is the largest response body that is buffered to compute an
+	// ETag. Larger responses are sent unchanged. Defaults to 1MB.
+	MaxSize int
+}
+
+// Conditional is ConditionalMiddleware with strong ETags.
+func Conditional(next http.Handler) http.Handler {
+	return ConditionalMiddleware(&ConditionalConfig{})(next)
+}
+
+// ConditionalMiddleware buffers successful GET and HEAD responses, adds an ETag
+// if the handler didn't set one and responds with 304 Not Modified when the
+// request's If-None-Match or If-Modified-Since shows the client is up to date.
+func ConditionalMiddleware(config *ConditionalConfig) MiddlewareFunc {
+	maxSize := config.MaxSize
+	if maxSize == 0 {
+		maxSize = 1 << 20
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method != "GET" && req.Method != "HEAD" || isUpgrade(req) {
+				next.ServeHTTP(rw, req)
+				return
+			}
+
+			buf := newResponseBuffer(rw, maxSize)
+			next.ServeHTTP(buf, req)
+			if buf.passthrough {
+				return
+			}
+			if buf.Status() != http.StatusOK {
+				buf.flush()
+				return
+			}
+
+			etag := buf.header.Get("ETag")
+			if etag == "" && buf.body.Len() > 0 {
+				sum := sha256.Sum256(buf.body.Bytes())
+				etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				if config.Weak {
+					etag = "W/" + etag
+				}
+				buf.header.Set("ETag", etag)
+			}
+			if notModified(req, etag, buf.header.Get("Last-Modified")) {
+				copyHeader(rw.Header(), buf.header)
+				writeNotModified(rw)
+				return
+			}
+			buf.flush()
+		})
+	}
+}
+
+// CheckETag sets the ETag header and, if the request's If-None-Match shows the
+// client already has this version, responds with 304 Not Modified. It returns
+// true if a response was written, in which case the handler should return.
+//
+//	if engine.CheckETag(rw, req, user.ETag()) {
+//		return
+//	}
+func CheckETag(rw http.ResponseWriter, req *http.Request, etag string) bool {
+	rw.Header().Set("ETag", etag)
+	if (req.Method == "GET" || req.Method == "HEAD") && notModified(req, etag, "") {
+		writeNotModified(rw)
+		return true
+	}
+	return false
+}
+
+// CheckPreconditions evaluates If-Match and If-Unmodified-Since against the
+// current etag and modification time of a resource, responding with 412
+// Precondition Failed if the client's copy is out of date. Pass an empty etag
+// or zero time if the resource doesn't exist or the validator isn't known.
+// It returns true if a response was written, in which case the handler should
+// return.
+func CheckPreconditions(rw http.ResponseWriter, req *http.Request, etag string, lastModified time.Time) bool {
+	if im := req.Header.Get("If-Match"); im != "" {
+		if !matchETag(im, etag, true) {
+			JSONError(rw, nil, http.StatusPreconditionFailed)
+			return true
+		}
+		return false
+	}
+	if ius := req.Header.Get("If-Unmodified-Since"); ius != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ius)
+		if err == nil && lastModified.Truncate(time.Second).After(t) {
+			JSONError(rw, nil, http.StatusPreconditionFailed)
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether the client's cached copy, as described by the
+// request's If-None-Match or If-Modified-Since headers, is still current.
+func notModified(req *http.Request, etag, lastModified string) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return matchETag(inm, etag, false)
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// matchETag reports whether etag matches any of the ETags in header. Strong
+// comparison, as required by If-Match, never matches weak ETags.
+func matchETag(header, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong {
+			if candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotModified(rw http.ResponseWriter) {
+	h := rw.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	rw.WriteHeader(http.StatusNotModified)
+}
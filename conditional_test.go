@@ -0,0 +1,114 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConditionalMiddleware(t *testing.T) {
+	modified := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := engine.NewRouter()
+	r.Use(engine.ConditionalMiddleware(&engine.ConditionalConfig{Weak: true, MaxSize: 16}))
+	r.Get("/doc", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		rw.Write([]byte("hello"))
+	})
+	r.Get("/big", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(strings.Repeat("x", 32)))
+	})
+	r.Get("/missing", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSONError(rw, nil, http.StatusNotFound)
+	})
+	r.Get("/stream", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("a"))
+		rw.(http.Flusher).Flush()
+		rw.Write([]byte("b"))
+	})
+	r.Post("/doc", writeMethod)
+	c := enginetest.New(t, r)
+
+	res := c.Get("/doc").Do().AssertStatus(http.StatusOK).AssertBody("hello")
+	etag := res.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag = %q, want a weak ETag", etag)
+	}
+	c.Get("/doc").Header("If-None-Match", `"other", `+strings.TrimPrefix(etag, "W/")).Do().
+		AssertStatus(http.StatusNotModified).
+		AssertHeader("Content-Type", "").
+		AssertHeader("ETag", etag).
+		AssertBody("")
+	c.Get("/doc").Header("If-None-Match", `"other"`).Do().AssertStatus(http.StatusOK)
+	c.Get("/doc").Header("If-Modified-Since", modified.Add(time.Hour).Format(http.TimeFormat)).Do().
+		AssertStatus(http.StatusNotModified)
+	c.Get("/doc").Header("If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat)).Do().
+		AssertStatus(http.StatusOK)
+
+	c.Get("/big").Do().AssertStatus(http.StatusOK).AssertHeader("ETag", "").AssertBody(strings.Repeat("x", 32))
+	c.Get("/stream").Do().AssertStatus(http.StatusOK).AssertHeader("ETag", "").AssertBody("ab")
+	c.Get("/missing").Do().AssertStatus(http.StatusNotFound).AssertHeader("ETag", "")
+	c.Post("/doc").Do().AssertStatus(http.StatusOK).AssertHeader("ETag", "")
+}
+
+func TestCheckETag(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/user", func(rw http.ResponseWriter, req *http.Request) {
+		if engine.CheckETag(rw, req, `"v2"`) {
+			return
+		}
+		rw.Write([]byte("user"))
+	})
+	c := enginetest.New(t, r)
+	c.Get("/user").Header("If-None-Match", `"v2"`).Do().AssertStatus(http.StatusNotModified).AssertHeader("ETag", `"v2"`)
+	c.Get("/user").Header("If-None-Match", `"v1"`).Do().AssertStatus(http.StatusOK).AssertBody("user")
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	etag := `"v2"`
+	r := engine.NewRouter()
+	r.Put("/user", func(rw http.ResponseWriter, req *http.Request) {
+		if engine.CheckPreconditions(rw, req, etag, modified) {
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	c := enginetest.New(t, r)
+
+	cases := []struct {
+		header, value string
+		want          int
+	}{
+		{"If-Match", `"v2"`, http.StatusNoContent},
+		{"If-Match", `"v1", "v2"`, http.StatusNoContent},
+		{"If-Match", "*", http.StatusNoContent},
+		{"If-Match", `"v1"`, http.StatusPreconditionFailed},
+		{"If-Match", `W/"v2"`, http.StatusPreconditionFailed},
+		{"If-Unmodified-Since", modified.Format(http.TimeFormat), http.StatusNoContent},
+		{"If-Unmodified-Since", modified.Add(-time.Minute).Format(http.TimeFormat), http.StatusPreconditionFailed},
+	}
+	for _, tc := range cases {
+		res := c.Put("/user").Header(tc.header, tc.value).Do()
+		if res.Code != tc.want {
+			t.Errorf("%s: %s = %d, want %d", tc.header, tc.value, res.Code, tc.want)
+		}
+	}
+}
+
+func TestConditionalWebSocket(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(engine.Conditional)
+	r.WebSocket("/ws", nil, func(c *engine.WebSocketConn) {
+		c.WriteMessage(engine.TextMessage, []byte("hi"))
+		c.ReadMessage()
+	})
+	c, res := dial(t, r, websocketRequest("/ws"))
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", res.StatusCode)
+	}
+	c.expectMessage(engine.TextMessage, "hi")
+}
@@ -13,7 +13,16 @@ type Context struct {
 	io.ReadCloser
 	mutex  sync.RWMutex
 	Params httprouter.Params
-	store  map[interface{}]interface{}
+	// HostParams are the host labels captured by the pattern given to
+	// Router.Host.
+	HostParams httprouter.Params
+	// Route is the route that matched the request.
+	Route    *Route
+	router   *Router
+	store    map[interface{}]interface{}
+	onFinish []func()
+	holds    int
+	finished bool
 }
 
 func (c *Context) Wrap(ctx context.Context) {
@@ -36,12 +45,58 @@ func (c *Context) Value(key interface{}) interface{} {
 	return c.Context.Value(key)
 }
 
+// OnFinish registers fn to be called once the router has finished handling
+// the request, and any handler left running by Timeout has returned, such as
+// to release resources held for its duration.
+func (c *Context) OnFinish(fn func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onFinish = append(c.onFinish, fn)
+}
+
+// hold delays the OnFinish callbacks until the returned func is called, for
+// handlers that may outlive the router's call.
+func (c *Context) hold() func() {
+	c.mutex.Lock()
+	c.holds++
+	c.mutex.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			c.holds--
+			c.runFinish()
+		})
+	}
+}
+
+func (c *Context) finish() {
+	c.mutex.Lock()
+	c.finished = true
+	c.runFinish()
+}
+
+// runFinish calls the OnFinish callbacks if the request is finished and
+// nothing holds it. It's called with c.mutex held and releases it.
+func (c *Context) runFinish() {
+	if !c.finished || c.holds > 0 {
+		c.mutex.Unlock()
+		return
+	}
+	fns := c.onFinish
+	c.onFinish = nil
+	c.mutex.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
+
 func GetContext(req *http.Request) *Context {
 	ctx, ok := req.Body.(*Context)
 	if !ok {
 		ctx = &Context{
 			ReadCloser: req.Body,
-			Context:    context.Background(),
+			Context:    req.Context(),
 			store:      make(map[interface{}]interface{}),
 		}
 		req.Body = ctx
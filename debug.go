@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"expvar"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/trace"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Debug mounts debugging endpoints under prefix, all behind auth:
+//
+//	pprof/     net/http/pprof's profiles
+//	vars       expvar's variables
+//	requests   x/net/trace's recent requests
+//	events     x/net/trace's event logs
+//	routes     the route table as JSON
+//	logging    GET or PUT the log level and the routes with debug logging
+//
+// The log level is that of the logger set with WithLogger, if any. The debug
+// routes skip the router's middleware other than auth, so they aren't access
+// logged, and are left out of the OpenAPI document.
+func (r *Router) Debug(prefix string, auth MiddlewareFunc) {
+	if auth == nil {
+		panic("engine: Debug needs auth middleware")
+	}
+	d := &Router{
+		mux:          r.mux,
+		routes:       r.routes,
+		absolutePath: r.calculateAbsolutePath(prefix),
+		middleware:   newMiddlewareEntries([]MiddlewareFunc{auth}),
+	}
+	d.Get("/pprof/*name", debugPprof).Hidden()
+	d.Post("/pprof/*name", debugPprof).Hidden()
+	d.Handle("GET", "/vars", expvar.Handler()).Hidden()
+	d.Get("/requests", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		trace.Render(rw, req, true)
+	}).Hidden()
+	d.Get("/events", func(rw http.ResponseWriter, req *http.Request) {
+		trace.RenderEvents(rw, req, true)
+	}).Hidden()
+	d.Get("/routes", r.debugRoutes).Hidden()
+	d.Get("/logging", r.debugLogging).Hidden()
+	d.Put("/logging", r.debugSetLogging).Hidden()
+}
+
+func debugPprof(rw http.ResponseWriter, req *http.Request) {
+	switch name := strings.TrimPrefix(GetContext(req).Params.ByName("name"), "/"); name {
+	case "":
+		pprof.Index(rw, req)
+	case "cmdline":
+		pprof.Cmdline(rw, req)
+	case "profile":
+		pprof.Profile(rw, req)
+	case "symbol":
+		pprof.Symbol(rw, req)
+	case "trace":
+		pprof.Trace(rw, req)
+	default:
+		pprof.Handler(name).ServeHTTP(rw, req)
+	}
+}
+
+type debugRoute struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Hidden  bool   `json:"hidden,omitempty"`
+	Debug   bool   `json:"debug,omitempty"`
+}
+
+func (r *Router) debugRoutes(rw http.ResponseWriter, req *http.Request) {
+	routes := []debugRoute{}
+	for _, rt := range r.Routes() {
+		rt.mu.Lock()
+		hidden := rt.hidden
+		rt.mu.Unlock()
+		routes = append(routes, debugRoute{Method: rt.Method, Path: rt.Path, Version: rt.Version, Action: rt.Action, Hidden: hidden, Debug: rt.debug.Load()})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		if routes[i].Method != routes[j].Method {
+			return routes[i].Method < routes[j].Method
+		}
+		return routes[i].Version < routes[j].Version
+	})
+	JSON(rw, routes, http.StatusOK)
+}
+
+// debugLoggingState is the logging endpoint's document. Routes are named
+// "METHOD /path", as registered, with " v<version>" after versioned routes.
+type debugLoggingState struct {
+	Level  string          `json:"level"`
+	Routes map[string]bool `json:"routes"`
+}
+
+func (r *Router) debugLogging(rw http.ResponseWriter, req *http.Request) {
+	state := debugLoggingState{Level: loggerLevel(r.logger()).String(), Routes: map[string]bool{}}
+	for _, rt := range r.Routes() {
+		if rt.debug.Load() {
+			state.Routes[debugName(rt)] = true
+		}
+	}
+	JSON(rw, state, http.StatusOK)
+}
+
+// debugSetLogging changes the router's log level and turns debug logging on or off
+// for the routes given. Nothing changes unless the whole request is valid.
+func (r *Router) debugSetLogging(rw http.ResponseWriter, req *http.Request) {
+	var update debugLoggingState
+	if err := BindJSON(req, &update); err != nil {
+		JSONError(rw, err, http.StatusBadRequest)
+		return
+	}
+
+	logger := r.logger()
+	var errs ValidationErrors
+	level := loggerLevel(logger)
+	if update.Level != "" {
+		var err error
+		if level, err = log.ParseLevel(update.Level); err != nil {
+			errs = append(errs, FieldError{Field: "level", Source: "body", Message: "must be one of panic, fatal, error, warning, info, debug"})
+		}
+	}
+	routes := make(map[string]*Route)
+	for _, rt := range r.Routes() {
+		routes[debugName(rt)] = rt
+	}
+	names := make([]string, 0, len(update.Routes))
+	for name := range update.Routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if routes[name] == nil {
+			errs = append(errs, FieldError{Field: "routes." + name, Source: "body", Message: "is not a route"})
+		}
+	}
+	if len(errs) > 0 {
+		JSONError(rw, errs, http.StatusUnprocessableEntity)
+		return
+	}
+
+	logger.SetLevel(level)
+	for name, enabled := range update.Routes {
+		routes[name].Debug(enabled)
+	}
+	logger.WithField("level", level.String()).Info("Changed logging")
+	r.debugLogging(rw, req)
+}
+
+// logger returns the logger set with WithLogger, or logrus's standard logger.
+func (r *Router) logger() *log.Logger {
+	if logger := r.routes.options.logger; logger != nil {
+		return logger
+	}
+	return log.StandardLogger()
+}
+
+// loggerLevel reads logger's level, which SetLevel may be changing.
+func loggerLevel(logger *log.Logger) log.Level {
+	return log.Level(atomic.LoadUint32((*uint32)(&logger.Level)))
+}
+
+func debugName(rt *Route) string {
+	if rt.Version != "" {
+		return rt.Method + " " + rt.Path + " v" + rt.Version
+	}
+	return rt.Method + " " + rt.Path
+}
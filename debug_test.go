@@ -0,0 +1,123 @@
+package engine_test
+
+import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			engine.JSONError(rw, nil, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func TestDebug(t *testing.T) {
+	logs := enginetest.CaptureLogs(t)
+	level := log.GetLevel()
+	t.Cleanup(func() { log.SetLevel(level) })
+	log.SetLevel(log.InfoLevel)
+
+	r := engine.NewRouter()
+	r.Get("/users/:id", func(rw http.ResponseWriter, req *http.Request) {
+		md, _ := engine.GetMetadata(engine.GetContext(req))
+		md.Logger().Debug("Loading user")
+	})
+	r.Debug("/debug", requireToken)
+
+	enginetest.New(t, r).Get("/debug/vars").Do().AssertStatus(http.StatusUnauthorized)
+	c := enginetest.New(t, r).Header("Authorization", "Bearer secret")
+	c.Get("/debug/vars").Do().AssertStatus(http.StatusOK).AssertBodyContains("memstats")
+	c.Get("/debug/pprof/").Do().AssertStatus(http.StatusOK).AssertBodyContains("goroutine")
+	c.Get("/debug/pprof/goroutine").Query("debug", "1").Do().AssertStatus(http.StatusOK)
+	c.Get("/debug/requests").Do().AssertStatus(http.StatusOK)
+	c.Get("/debug/routes").Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("0", engine.J{"method": "GET", "path": "/debug/events", "hidden": true})
+	if _, ok := logs.AccessLog("GET", "/debug/vars"); ok {
+		t.Error("debug request was access logged")
+	}
+
+	c.Get("/users/1").Do().AssertStatus(http.StatusOK)
+	if logs.Contains("Loading user") {
+		t.Fatal("debug message logged at info level")
+	}
+
+	c.Put("/debug/logging").JSON(engine.J{"level": "loud", "routes": engine.J{"GET /nope": true}}).Do().
+		AssertStatus(http.StatusUnprocessableEntity).
+		AssertJSONPath("errors.0.field", "level").
+		AssertJSONPath("errors.1.field", "routes.GET /nope")
+	c.Put("/debug/logging").JSON(engine.J{"routes": engine.J{"GET /users/:id": true}}).Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("level", "info").
+		AssertJSONPath("routes", engine.J{"GET /users/:id": true})
+	c.Get("/users/1").Do().AssertStatus(http.StatusOK)
+	if !logs.Contains("Loading user") {
+		t.Error("route debug logging wasn't turned on")
+	}
+
+	c.Put("/debug/logging").JSON(engine.J{"level": "warning"}).Do().AssertStatus(http.StatusOK)
+	if log.GetLevel() != log.WarnLevel {
+		t.Errorf("level = %v, want warning", log.GetLevel())
+	}
+}
+
+func TestDebugLoggerFollowsBaseLogger(t *testing.T) {
+	logger := log.New()
+	logger.Level = log.InfoLevel
+	logger.Formatter = &log.JSONFormatter{}
+	r := engine.NewRouter(engine.WithLogger(logger))
+	r.Get("/users/:id", func(rw http.ResponseWriter, req *http.Request) {
+		md, _ := engine.GetMetadata(engine.GetContext(req))
+		md.Logger().Debug("Loading user")
+	}).Debug(true)
+	c := enginetest.New(t, r)
+
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		logger.Out = &buf
+		sink := &enginetest.LogSink{}
+		logger.Hooks = log.LevelHooks{}
+		logger.Hooks.Add(sink)
+
+		c.Get("/users/1").Do().AssertStatus(http.StatusOK)
+		if !strings.Contains(buf.String(), `"msg":"Loading user"`) {
+			t.Errorf("debug message not written to the logger's output: %q", buf.String())
+		}
+		if !sink.Contains("Loading user") {
+			t.Error("debug message didn't fire the logger's hooks")
+		}
+	}
+}
+
+func TestDebugLoggingWithLogger(t *testing.T) {
+	level := log.GetLevel()
+	t.Cleanup(func() { log.SetLevel(level) })
+	log.SetLevel(log.InfoLevel)
+
+	logger := log.New()
+	logger.Out = &bytes.Buffer{}
+	logger.Level = log.ErrorLevel
+	r := engine.NewRouter(engine.WithLogger(logger))
+	r.Debug("/debug", requireToken)
+
+	c := enginetest.New(t, r).Header("Authorization", "Bearer secret")
+	c.Get("/debug/logging").Do().AssertStatus(http.StatusOK).AssertJSONPath("level", "error")
+	c.Put("/debug/logging").JSON(engine.J{"level": "debug"}).Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("level", "debug")
+	if logger.Level != log.DebugLevel {
+		t.Errorf("logger level = %v, want debug", logger.Level)
+	}
+	if log.GetLevel() != log.InfoLevel {
+		t.Errorf("standard logger level = %v, want info", log.GetLevel())
+	}
+}
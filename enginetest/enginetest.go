@@ -0,0 +1,228 @@
+// Package enginetest runs requests against engine Routers in-process and
+// makes assertions about the responses.
+//
+//	client := enginetest.New(t, router)
+//	client.Get("/users/1").
+//		Header("Authorization", "Bearer token").
+//		Do().
+//		AssertStatus(http.StatusOK).
+//		AssertJSONPath("name", "Matthew")
+package enginetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mnbbrown/engine"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Client sends requests to a handler, usually an *engine.Router.
+type Client struct {
+	t       testing.TB
+	handler http.Handler
+	header  http.Header
+}
+
+// New returns a Client that serves requests with handler.
+func New(t testing.TB, handler http.Handler) *Client {
+	return &Client{t: t, handler: handler, header: make(http.Header)}
+}
+
+// Header sets a header sent with every request made by the client.
+func (c *Client) Header(key, value string) *Client {
+	c.header.Set(key, value)
+	return c
+}
+
+// Request starts building a request with the given method and target, which
+// may include a query string.
+func (c *Client) Request(method, target string) *Request {
+	header := make(http.Header)
+	for k, v := range c.header {
+		header[k] = append([]string(nil), v...)
+	}
+	return &Request{client: c, method: method, target: target, header: header, query: make(url.Values)}
+}
+
+// Get starts building a GET request.
+func (c *Client) Get(target string) *Request {
+	return c.Request("GET", target)
+}
+
+// Head starts building a HEAD request.
+func (c *Client) Head(target string) *Request {
+	return c.Request("HEAD", target)
+}
+
+// Post starts building a POST request.
+func (c *Client) Post(target string) *Request {
+	return c.Request("POST", target)
+}
+
+// Put starts building a PUT request.
+func (c *Client) Put(target string) *Request {
+	return c.Request("PUT", target)
+}
+
+// Patch starts building a PATCH request.
+func (c *Client) Patch(target string) *Request {
+	return c.Request("PATCH", target)
+}
+
+// Delete starts building a DELETE request.
+func (c *Client) Delete(target string) *Request {
+	return c.Request("DELETE", target)
+}
+
+// Options starts building an OPTIONS request.
+func (c *Client) Options(target string) *Request {
+	return c.Request("OPTIONS", target)
+}
+
+// Request is a request being built by a Client.
+type Request struct {
+	client   *Client
+	method   string
+	target   string
+	header   http.Header
+	query    url.Values
+	body     io.Reader
+	params   []string
+	values   []interface{}
+	metadata *engine.RequestMetadata
+}
+
+// Header sets a request header.
+func (r *Request) Header(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+// Query adds a query string value.
+func (r *Request) Query(key, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+// Body sets the request body and its content type.
+func (r *Request) Body(contentType string, body io.Reader) *Request {
+	r.header.Set("Content-Type", contentType)
+	r.body = body
+	return r
+}
+
+// JSON sets the request body to v encoded as JSON.
+func (r *Request) JSON(v interface{}) *Request {
+	data, err := json.Marshal(v)
+	if err != nil {
+		r.client.t.Fatalf("enginetest: encoding request body: %v", err)
+	}
+	return r.Body("application/json", bytes.NewReader(data))
+}
+
+// Form sets the request body to URL encoded form values.
+func (r *Request) Form(values url.Values) *Request {
+	return r.Body("application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+}
+
+// Param presets a path param on the request's Context. The router replaces
+// params with those from the matched route, so this is only useful when the
+// client's handler isn't a Router.
+func (r *Request) Param(key, value string) *Request {
+	r.params = append(r.params, key, value)
+	return r
+}
+
+// Value presets a value on the request's Context.
+func (r *Request) Value(key, value interface{}) *Request {
+	r.values = append(r.values, key, value)
+	return r
+}
+
+// Metadata presets the request's metadata, such as one from FakeMetadata.
+// MetadataMiddleware won't replace it, so the request isn't access logged.
+func (r *Request) Metadata(md *engine.RequestMetadata) *Request {
+	r.metadata = md
+	return r
+}
+
+// Build returns the request as an *http.Request without sending it.
+func (r *Request) Build() *http.Request {
+	target := r.target
+	if len(r.query) > 0 {
+		u, err := url.Parse(target)
+		if err != nil {
+			r.client.t.Fatalf("enginetest: parsing %q: %v", target, err)
+		}
+		q := u.Query()
+		for k, v := range r.query {
+			q[k] = append(q[k], v...)
+		}
+		u.RawQuery = q.Encode()
+		target = u.String()
+	}
+	req := httptest.NewRequest(r.method, target, r.body)
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if len(r.params) > 0 {
+		WithParams(req, r.params...)
+	}
+	for i := 0; i < len(r.values); i += 2 {
+		WithValue(req, r.values[i], r.values[i+1])
+	}
+	if r.metadata != nil {
+		WithMetadata(req, r.metadata)
+	}
+	return req
+}
+
+// Do sends the request to the client's handler and returns the response.
+func (r *Request) Do() *Response {
+	req := r.Build()
+	rec := httptest.NewRecorder()
+	r.client.handler.ServeHTTP(rec, req)
+	return &Response{ResponseRecorder: rec, Request: req, t: r.client.t}
+}
+
+// WithParams sets path params on the request's Context from key, value pairs,
+// for calling handlers directly rather than through a Router.
+func WithParams(req *http.Request, kv ...string) *http.Request {
+	if len(kv)%2 != 0 {
+		panic("enginetest: WithParams needs key, value pairs")
+	}
+	ctx := engine.GetContext(req)
+	for i := 0; i < len(kv); i += 2 {
+		ctx.Params = append(ctx.Params, httprouter.Param{Key: kv[i], Value: kv[i+1]})
+	}
+	return req
+}
+
+// WithValue sets a value on the request's Context.
+func WithValue(req *http.Request, key, value interface{}) *http.Request {
+	engine.GetContext(req).Set(key, value)
+	return req
+}
+
+// WithMetadata sets the request's metadata.
+func WithMetadata(req *http.Request, md *engine.RequestMetadata) *http.Request {
+	engine.SetMetadata(engine.GetContext(req), md)
+	return req
+}
+
+// FakeMetadata returns RequestMetadata with fixed values, for handlers that
+// log or read the request ID.
+func FakeMetadata() *engine.RequestMetadata {
+	return &engine.RequestMetadata{
+		RequestID: "00000000-0000-0000-0000-000000000000",
+		IP:        "192.0.2.1",
+		StartTime: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
@@ -0,0 +1,140 @@
+package enginetest
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/mnbbrown/engine"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// recordingT records failures instead of failing the test.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func echo(rw http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	ctx := engine.GetContext(req)
+	engine.JSON(rw, engine.J{
+		"method": req.Method,
+		"query":  req.URL.Query(),
+		"form":   req.PostForm,
+		"auth":   req.Header.Get("Authorization"),
+		"id":     ctx.Params.ByName("id"),
+		"value":  ctx.Value("key"),
+	}, http.StatusOK)
+}
+
+func TestRequestBuilder(t *testing.T) {
+	c := New(t, http.HandlerFunc(echo)).Header("Authorization", "Bearer token")
+	c.Post("/?a=1").
+		Query("a", "2").
+		Query("b", "3").
+		Form(url.Values{"name": {"matthew"}}).
+		Param("id", "42").
+		Value("key", "value").
+		Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("method", "POST").
+		AssertJSONPath("query.a", []string{"1", "2"}).
+		AssertJSONPath("query.b.0", "3").
+		AssertJSONPath("form.name", []string{"matthew"}).
+		AssertJSONPath("auth", "Bearer token").
+		AssertJSONPath("id", "42").
+		AssertJSONPath("value", "value")
+}
+
+func TestRequestJSON(t *testing.T) {
+	r := engine.NewRouter()
+	r.Post("/", func(rw http.ResponseWriter, req *http.Request) {
+		j, err := engine.ParseJSON(req)
+		if err != nil {
+			engine.JSONError(rw, err, http.StatusBadRequest)
+			return
+		}
+		engine.JSON(rw, j, http.StatusOK)
+	})
+	var out struct{ Count int }
+	New(t, r).Post("/").JSON(engine.J{"Count": 3}).Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("Count", 3).
+		AssertJSONPath("", map[string]int{"Count": 3}).
+		DecodeJSON(&out)
+	if out.Count != 3 {
+		t.Errorf("Count = %d, want 3", out.Count)
+	}
+}
+
+func TestAssertionsFail(t *testing.T) {
+	if *updateGolden {
+		t.Skip("golden files are being updated")
+	}
+	rt := &recordingT{TB: t}
+	New(rt, http.HandlerFunc(echo)).Get("/").Do().
+		AssertStatus(http.StatusCreated).
+		AssertHeader("Content-Type", "text/plain").
+		AssertBody("nope").
+		AssertBodyContains("nope").
+		AssertJSONPath("method", "POST").
+		AssertJSONPath("missing", 1).
+		AssertJSONPath("method.0", 1).
+		AssertGolden("does_not_exist")
+	if len(rt.errors) != 8 {
+		t.Errorf("got %d failures, want 8: %q", len(rt.errors), rt.errors)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/users/:id", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, engine.J{"id": engine.GetContext(req).Params.ByName("id"), "name": "Matthew"}, http.StatusOK)
+	})
+	New(t, r).Get("/users/1").Do().AssertGolden("user")
+}
+
+func TestFakeMetadata(t *testing.T) {
+	req := WithMetadata(WithParams(newRequest(), "id", "1"), FakeMetadata())
+	ctx := engine.GetContext(req)
+	md, ok := engine.GetMetadata(ctx)
+	if !ok || md.RequestID != FakeMetadata().RequestID {
+		t.Errorf("metadata = %+v", md)
+	}
+	if ctx.Params.ByName("id") != "1" {
+		t.Errorf("params = %v", ctx.Params)
+	}
+}
+
+func newRequest() *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	return req
+}
+
+func TestCaptureLogs(t *testing.T) {
+	logs := CaptureLogs(t)
+	log.WithField("user", "matthew").Warn("hello")
+	entries := logs.Entries()
+	if len(entries) != 1 || entries[0].Message != "hello" || entries[0].Level != log.WarnLevel || entries[0].Fields["user"] != "matthew" {
+		t.Errorf("entries = %+v", entries)
+	}
+	if !logs.Contains("hell") || logs.Contains("bye") {
+		t.Errorf("Contains is wrong")
+	}
+	logs.Reset()
+	if len(logs.Entries()) != 0 {
+		t.Errorf("Reset didn't discard entries")
+	}
+
+	New(t, engine.NewRouter()).Get("/missing").Do()
+	if _, ok := logs.AccessLog("GET", "/missing"); !ok {
+		t.Errorf("no access log entry in %+v", logs.Entries())
+	}
+}
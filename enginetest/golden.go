@@ -0,0 +1,47 @@
+package enginetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite enginetest golden files with the responses received")
+
+// AssertGolden compares the response body with testdata/<name>.golden. JSON
+// bodies are indented first so golden files are easy to review. Run the tests
+// with -update-golden to create or update the files.
+func (r *Response) AssertGolden(name string) *Response {
+	r.t.Helper()
+	got := r.Body.Bytes()
+	if strings.Contains(r.Header().Get("Content-Type"), "json") {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, got, "", "  "); err == nil {
+			got = buf.Bytes()
+		}
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			r.t.Fatalf("enginetest: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			r.t.Fatalf("enginetest: %v", err)
+		}
+		return r
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		r.t.Errorf("%s %s: %v (run with -update-golden to create it)", r.Request.Method, r.Request.URL, err)
+		return r
+	}
+	if !bytes.Equal(got, want) {
+		r.t.Errorf("%s %s: body doesn't match %s\ngot:\n%s\nwant:\n%s", r.Request.Method, r.Request.URL, path, got, want)
+	}
+	return r
+}
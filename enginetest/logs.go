@@ -0,0 +1,91 @@
+package enginetest
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// LogEntry is a captured log entry.
+type LogEntry struct {
+	Level   log.Level
+	Message string
+	Fields  log.Fields
+}
+
+// LogSink captures the entries logged to logrus' standard logger, which is
+// where engine writes its access log.
+type LogSink struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+// CaptureLogs captures log entries, rather than writing them out, until the
+// test finishes. The standard logger is global, so tests using CaptureLogs
+// shouldn't run in parallel.
+func CaptureLogs(t testing.TB) *LogSink {
+	sink := &LogSink{}
+	logger := log.StandardLogger()
+	out, hooks := logger.Out, logger.Hooks
+	logger.Out = io.Discard
+	logger.Hooks = log.LevelHooks{}
+	logger.Hooks.Add(sink)
+	t.Cleanup(func() {
+		logger.Out, logger.Hooks = out, hooks
+	})
+	return sink
+}
+
+func (s *LogSink) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (s *LogSink) Fire(entry *log.Entry) error {
+	fields := make(log.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		fields[k] = v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, LogEntry{Level: entry.Level, Message: entry.Message, Fields: fields})
+	return nil
+}
+
+// Entries returns the entries captured so far.
+func (s *LogSink) Entries() []LogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LogEntry(nil), s.entries...)
+}
+
+// Reset discards the entries captured so far.
+func (s *LogSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+}
+
+// Contains reports whether an entry's message contains msg.
+func (s *LogSink) Contains(msg string) bool {
+	for _, e := range s.Entries() {
+		if strings.Contains(e.Message, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessLog returns the last access log entry for a request with the given
+// method and path.
+func (s *LogSink) AccessLog(method, path string) (LogEntry, bool) {
+	entries := s.Entries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if _, ok := e.Fields["status"]; ok && e.Fields["method"] == method && e.Fields["path"] == path {
+			return e, true
+		}
+	}
+	return LogEntry{}, false
+}
@@ -0,0 +1,131 @@
+package enginetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Response is the recorded response to a Request. Assertions report failures
+// with t.Errorf and return the Response so they can be chained.
+type Response struct {
+	*httptest.ResponseRecorder
+	// Request is the request that was sent, whose Context can be inspected
+	// after the handler has run.
+	Request *http.Request
+
+	t    testing.TB
+	json interface{}
+	err  error
+	read bool
+}
+
+// AssertStatus checks the response's status code.
+func (r *Response) AssertStatus(code int) *Response {
+	r.t.Helper()
+	if r.Code != code {
+		r.t.Errorf("%s %s: status = %d, want %d\n%s", r.Request.Method, r.Request.URL, r.Code, code, r.Body.String())
+	}
+	return r
+}
+
+// AssertHeader checks a response header's value. An empty want checks the
+// header is absent.
+func (r *Response) AssertHeader(key, want string) *Response {
+	r.t.Helper()
+	if got := r.Header().Get(key); got != want {
+		r.t.Errorf("%s %s: header %s = %q, want %q", r.Request.Method, r.Request.URL, key, got, want)
+	}
+	return r
+}
+
+// AssertBody checks the response body.
+func (r *Response) AssertBody(want string) *Response {
+	r.t.Helper()
+	if got := r.Body.String(); got != want {
+		r.t.Errorf("%s %s: body = %q, want %q", r.Request.Method, r.Request.URL, got, want)
+	}
+	return r
+}
+
+// AssertBodyContains checks the response body contains s.
+func (r *Response) AssertBodyContains(s string) *Response {
+	r.t.Helper()
+	if !strings.Contains(r.Body.String(), s) {
+		r.t.Errorf("%s %s: body %q doesn't contain %q", r.Request.Method, r.Request.URL, r.Body.String(), s)
+	}
+	return r
+}
+
+// JSONPath returns the value at path in the JSON response body. Paths are dot
+// separated object keys and array indexes, such as "data.0.name". The empty
+// path is the whole body. Numbers are float64s, as with encoding/json.
+func (r *Response) JSONPath(path string) (interface{}, error) {
+	if !r.read {
+		r.read = true
+		r.err = json.Unmarshal(r.Body.Bytes(), &r.json)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("body isn't JSON: %v", r.err)
+	}
+	v := r.json
+	if path == "" {
+		return v, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("%s: no key %q", path, key)
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("%s: no index %q in array of %d", path, key, len(node))
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("%s: can't index %q into %v", path, key, node)
+		}
+	}
+	return v, nil
+}
+
+// AssertJSONPath checks the value at path in the JSON response body. want is
+// compared after a round trip through encoding/json, so ints, structs and
+// maps can be used.
+func (r *Response) AssertJSONPath(path string, want interface{}) *Response {
+	r.t.Helper()
+	got, err := r.JSONPath(path)
+	if err != nil {
+		r.t.Errorf("%s %s: %v\n%s", r.Request.Method, r.Request.URL, err, r.Body.String())
+		return r
+	}
+	data, err := json.Marshal(want)
+	if err != nil {
+		r.t.Fatalf("enginetest: encoding %v: %v", want, err)
+	}
+	var normalized interface{}
+	json.Unmarshal(data, &normalized)
+	if !reflect.DeepEqual(got, normalized) {
+		gotData, _ := json.Marshal(got)
+		r.t.Errorf("%s %s: JSON %q = %s, want %s", r.Request.Method, r.Request.URL, path, gotData, data)
+	}
+	return r
+}
+
+// DecodeJSON decodes the response body into v.
+func (r *Response) DecodeJSON(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body.Bytes(), v); err != nil {
+		r.t.Fatalf("%s %s: decoding body: %v\n%s", r.Request.Method, r.Request.URL, err, r.Body.String())
+	}
+	return r
+}
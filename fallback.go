@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// allowMethods are the methods looked up for the Allow header, in the order
+// they're listed.
+var allowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "TRACE"}
+
+// unmatched serves requests that match no route. HEAD requests are answered
+// by the path's GET route, OPTIONS requests list the path's methods, and the
+// rest get a 405 if the path has routes for other methods or a 404. Those not
+// served by a route are wrapped in the router's middleware.
+func (r *Router) unmatched(rw http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	if req.Method == "HEAD" {
+		if handle, params, _ := r.mux.Lookup("GET", path); handle != nil {
+			hw := &headResponseWriter{ResponseWriter: rw}
+			handle(hw, req, params)
+			hw.finish()
+			return
+		}
+	}
+
+	allow := r.allowed(path)
+	handler := r.notFoundHandler()
+	switch {
+	case len(allow) == 0 || containsMethod(allow, req.Method) && req.Method != "OPTIONS":
+	case req.Method == "OPTIONS":
+		handler = http.HandlerFunc(options)
+		rw.Header().Set("Allow", strings.Join(allow, ", "))
+	case r.routes.options.handleMethodNotAllowed:
+		r.routes.mu.Lock()
+		handler = r.routes.methodNotAllowed
+		r.routes.mu.Unlock()
+		rw.Header().Set("Allow", strings.Join(allow, ", "))
+	}
+	GetContext(req).Set(fallbackCtxKey, handler)
+	wrap(r.fallback, nil)(rw, req, nil)
+}
+
+// fallbackCtxKey holds the handler unmatched chose, for serveFallback.
+const fallbackCtxKey key = 2
+
+// serveFallback serves unmatched requests, inside the router's middleware,
+// with the handler unmatched chose.
+func serveFallback(rw http.ResponseWriter, req *http.Request) {
+	GetContext(req).Value(fallbackCtxKey).(http.Handler).ServeHTTP(rw, req)
+}
+
+// allowed returns the methods the path has routes for, with HEAD if it has a
+// GET route and OPTIONS if it has any.
+func (r *Router) allowed(path string) (allow []string) {
+	get := false
+	for _, method := range allowMethods {
+		if handle, _, _ := r.mux.Lookup(method, path); handle != nil || method == "HEAD" && get {
+			allow = append(allow, method)
+			get = get || method == "GET"
+		}
+	}
+	if handle, _, _ := r.mux.Lookup("OPTIONS", path); len(allow) > 0 || handle != nil {
+		allow = append(allow, "OPTIONS")
+	}
+	return allow
+}
+
+func options(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// headResponseWriter answers a HEAD request with a GET handler. It discards
+// the body, counting it so Content-Length is what the GET response's would be.
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.length += len(b)
+	return len(b), nil
+}
+
+// finish writes the response's header once the handler has returned.
+func (w *headResponseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	h := w.Header()
+	if h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
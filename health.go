@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checker checks a dependency, returning an error if it's unhealthy. It
+// should give up when ctx is done.
+type Checker func(ctx context.Context) error
+
+// CheckConfig configures a registered Checker.
+type CheckConfig struct {
+	// Timeout is how long the check may run before it's failed. Defaults to
+	// 5 seconds.
+	Timeout time.Duration
+	// Critical checks fail the endpoints they're part of. Other failing
+	// checks only mark the result as degraded.
+	Critical bool
+	// CacheTTL is how long a result is reused for before the check is run
+	// again. Zero runs the check on every request.
+	CacheTTL time.Duration
+	// Liveness includes the check in /livez, and leaves it out of /readyz.
+	// Only use it for problems a restart would fix, such as deadlocks.
+	Liveness bool
+}
+
+// Health serves /livez, /readyz and /healthz, running the registered
+// checks concurrently and reporting their results as JSON.
+type Health struct {
+	mu           sync.RWMutex
+	checks       []*healthCheck
+	shuttingDown atomic.Bool
+}
+
+type healthCheck struct {
+	name    string
+	checker Checker
+	config  CheckConfig
+
+	mu      sync.Mutex
+	result  CheckResult
+	expires time.Time
+}
+
+// CheckResult is the outcome of a check.
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Cached    bool      `json:"cached,omitempty"`
+}
+
+// HealthReport is the JSON document served by the health endpoints.
+type HealthReport struct {
+	// Status is "ok", "degraded" if a non-critical check failed, or "fail".
+	Status       string                 `json:"status"`
+	ShuttingDown bool                   `json:"shutting_down,omitempty"`
+	Duration     float64                `json:"duration_ms"`
+	Checks       map[string]CheckResult `json:"checks"`
+}
+
+// Health mounts /livez, /readyz and /healthz under prefix, which is taken to
+// start with a "/" if it doesn't, and returns the Health they report on, for
+// registering checks.
+//
+// /livez runs liveness checks, /readyz the others and fails once shutdown has
+// begun, and /healthz runs every check. Failing endpoints respond with 503.
+// Only failures are access logged, as probes poll the endpoints frequently.
+func (r *Router) Health(prefix string) *Health {
+	h := &Health{}
+	prefix = path.Join("/", prefix)
+	r.Get(path.Join(prefix, "livez"), h.handler(func(c *healthCheck) bool { return c.config.Liveness }, false)).Hidden().Quiet()
+	r.Get(path.Join(prefix, "readyz"), h.handler(func(c *healthCheck) bool { return !c.config.Liveness }, true)).Hidden().Quiet()
+	r.Get(path.Join(prefix, "healthz"), h.handler(func(c *healthCheck) bool { return true }, true)).Hidden().Quiet()
+	return h
+}
+
+// Register adds a named check. A nil config uses the defaults, which make the
+// check non-critical.
+func (h *Health) Register(name string, checker Checker, config *CheckConfig) {
+	if config == nil {
+		config = &CheckConfig{}
+	}
+	c := &healthCheck{name: name, checker: checker, config: *config}
+	if c.config.Timeout == 0 {
+		c.config.Timeout = 5 * time.Second
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, c)
+}
+
+// Shutdown makes /readyz fail, so load balancers stop sending traffic while
+// in-flight requests finish.
+func (h *Health) Shutdown() {
+	h.shuttingDown.Store(true)
+}
+
+// Attach calls Shutdown once srv begins a graceful shutdown.
+func (h *Health) Attach(srv *http.Server) {
+	srv.RegisterOnShutdown(h.Shutdown)
+}
+
+// check runs the checks selected by include concurrently and reports on them.
+func (h *Health) check(include func(*healthCheck) bool) HealthReport {
+	start := time.Now()
+	h.mu.RLock()
+	var checks []*healthCheck
+	for _, c := range h.checks {
+		if include(c) {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = c.run()
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	for i, c := range checks {
+		result := results[i]
+		report.Checks[c.name] = result
+		if result.Status != "fail" {
+			continue
+		}
+		if c.config.Critical {
+			report.Status = "fail"
+		} else if report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+	report.Duration = milliseconds(time.Since(start))
+	return report
+}
+
+func (h *Health) handler(include func(*healthCheck) bool, honourShutdown bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		report := h.check(include)
+		if honourShutdown && h.shuttingDown.Load() {
+			report.ShuttingDown = true
+			report.Status = "fail"
+		}
+		code := http.StatusOK
+		if report.Status == "fail" {
+			code = http.StatusServiceUnavailable
+		}
+		rw.Header().Set("Cache-Control", "no-store")
+		JSON(rw, report, code)
+	}
+}
+
+// run returns the check's cached result if it's fresh, or runs it. Concurrent
+// callers wait for a single run.
+func (c *healthCheck) run() CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expires) {
+		result := c.result
+		result.Cached = true
+		return result
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				done <- fmt.Errorf("panic: %v", err)
+			}
+		}()
+		done <- c.checker(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %v", c.config.Timeout)
+	}
+	result := CheckResult{Status: "ok", Critical: c.config.Critical, CheckedAt: start.UTC(), Duration: milliseconds(time.Since(start))}
+	if err != nil {
+		result.Status, result.Error = "fail", err.Error()
+	}
+	c.result = result
+	c.expires = start.Add(c.config.CacheTTL)
+	return result
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
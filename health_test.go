@@ -0,0 +1,103 @@
+package engine_test
+
+import (
+	"errors"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"golang.org/x/net/context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	r := engine.NewRouter()
+	h := r.Health("/")
+	var dbErr error
+	var calls int32
+	h.Register("db", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return dbErr
+	}, &engine.CheckConfig{Critical: true})
+	h.Register("cache", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}, nil)
+	h.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, &engine.CheckConfig{Timeout: 10 * time.Millisecond, CacheTTL: time.Minute})
+	h.Register("deadlock", func(ctx context.Context) error {
+		return nil
+	}, &engine.CheckConfig{Liveness: true, Critical: true})
+	c := enginetest.New(t, r)
+
+	c.Get("/livez").Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("status", "ok").
+		AssertJSONPath("checks.deadlock.status", "ok")
+	res := c.Get("/readyz").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Cache-Control", "no-store").
+		AssertJSONPath("status", "degraded").
+		AssertJSONPath("checks.cache.error", "connection refused").
+		AssertJSONPath("checks.slow.error", "timed out after 10ms").
+		AssertJSONPath("checks.db.critical", true)
+	if _, err := res.JSONPath("checks.deadlock"); err == nil {
+		t.Errorf("liveness check run by /readyz")
+	}
+
+	dbErr = errors.New("down")
+	c.Get("/healthz").Do().
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertJSONPath("status", "fail").
+		AssertJSONPath("checks.db.error", "down").
+		AssertJSONPath("checks.slow.cached", true).
+		AssertJSONPath("checks.deadlock.status", "ok")
+	if calls != 2 {
+		t.Errorf("db checked %d times, want 2", calls)
+	}
+
+	dbErr = nil
+	h.Shutdown()
+	c.Get("/readyz").Do().
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertJSONPath("shutting_down", true)
+	c.Get("/livez").Do().AssertStatus(http.StatusOK)
+}
+
+func TestHealthAttach(t *testing.T) {
+	r := engine.NewRouter()
+	h := r.Health("/health")
+	srv := &http.Server{Handler: r}
+	h.Attach(srv)
+	c := enginetest.New(t, r)
+	c.Get("/health/readyz").Do().AssertStatus(http.StatusOK)
+
+	srv.Shutdown(context.Background())
+	deadline := time.Now().Add(time.Second)
+	for c.Get("/health/readyz").Do().Code != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("readiness didn't fail after shutdown")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHealthPrefix(t *testing.T) {
+	logs := enginetest.CaptureLogs(t)
+	r := engine.NewRouter()
+	h := r.Health("health")
+	c := enginetest.New(t, r)
+	c.Get("/health/livez").Do().AssertStatus(http.StatusOK)
+
+	// Probes are only access logged when they fail.
+	if _, ok := logs.AccessLog("GET", "/health/livez"); ok {
+		t.Error("successful probe was access logged")
+	}
+	h.Register("db", func(ctx context.Context) error { return errors.New("down") }, &engine.CheckConfig{Critical: true})
+	c.Get("/health/readyz").Do().AssertStatus(http.StatusServiceUnavailable)
+	if _, ok := logs.AccessLog("GET", "/health/readyz"); !ok {
+		t.Error("failing probe wasn't access logged")
+	}
+}
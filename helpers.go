@@ -1,9 +1,14 @@
 package engine
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type J map[string]interface{}
@@ -27,10 +32,99 @@ func JSONError(rw http.ResponseWriter, err error, code int) {
 	if err == nil {
 		err = errors.New(http.StatusText(code))
 	}
-	JSON(rw, J{
-		"status_code": code,
-		"message":     err.Error(),
-	}, code)
+	body := J{}
+	var se *StatusError
+	if errors.As(err, &se) {
+		for k, v := range se.Extensions {
+			body[k] = v
+		}
+	}
+	body["status_code"] = code
+	body["message"] = err.Error()
+	if errs, ok := err.(ValidationErrors); ok {
+		body["message"] = "Validation failed"
+		body["errors"] = errs
+	}
+	JSON(rw, body, code)
+}
+
+// Render writes v as JSON or XML, whichever the request's Accept header
+// prefers, defaulting to JSON. Requests accepting neither get a 406.
+func Render(rw http.ResponseWriter, req *http.Request, v interface{}, code int) {
+	rw.Header().Add("Vary", "Accept")
+	var body bytes.Buffer
+	var err error
+	switch negotiate(req.Header.Get("Accept")) {
+	case "application/json":
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		err = json.NewEncoder(&body).Encode(v)
+	case "application/xml":
+		rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		body.WriteString(xml.Header)
+		err = xml.NewEncoder(&body).Encode(v)
+	default:
+		RenderError(rw, req, nil, http.StatusNotAcceptable)
+		return
+	}
+	if err != nil {
+		rw.Header().Del("Content-Type")
+		RenderError(rw, req, err, http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(code)
+	rw.Write(body.Bytes())
+}
+
+// negotiate returns the media type Render writes for accept, or "" if it
+// accepts none. Each offer's quality comes from the most specific media range
+// matching it, and ties go to the more specific match, then to JSON.
+func negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return "application/json"
+	}
+	best, bestQ, bestSpecificity := "", 0.0, -1
+	for _, offer := range []string{"application/json", "application/xml"} {
+		q, specificity := 0.0, -1
+		for _, r := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(r)
+			if err != nil {
+				continue
+			}
+			s := matchMediaType(mediaType, offer)
+			if s <= specificity {
+				continue
+			}
+			q, specificity = 1.0, s
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > bestQ || q == bestQ && q > 0 && specificity > bestSpecificity {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
+
+// matchMediaType returns how specifically an Accept media range matches
+// offer: 2 for the type itself, 1 for a wildcard subtype and 0 for */*, or -1
+// if it doesn't. Structured syntax suffixes match too, so
+// application/vnd.acme+json accepts JSON.
+func matchMediaType(mediaRange, offer string) int {
+	typ, subtype, _ := strings.Cut(offer, "/")
+	switch {
+	case mediaRange == offer, mediaRange == "text/"+subtype:
+		return 2
+	case strings.HasPrefix(mediaRange, typ+"/") && strings.HasSuffix(mediaRange, "+"+subtype):
+		return 2
+	case mediaRange == typ+"/*":
+		return 1
+	case mediaRange == "*/*":
+		return 0
+	}
+	return -1
 }
 
 func ParseJSON(req *http.Request) (J, error) {
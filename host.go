@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hostRouter is a router serving requests whose Host matches pattern.
+type hostRouter struct {
+	labels []string
+	router *Router
+}
+
+// Host returns a router with its own routes and middleware, made with this
+// router's NewRouter options, serving requests whose Host matches pattern
+// instead of this router. Patterns are host names such as "api.example.com",
+// and labels like {tenant} match any single label, as in
+// "{tenant}.example.com". The matched labels are in Context.HostParams.
+//
+// Patterns are tried in the order they were added, and requests matching
+// none are served by this router's own routes. The request's port is ignored.
+func (r *Router) Host(pattern string) *Router {
+	hr := &hostRouter{labels: strings.Split(strings.ToLower(pattern), "."), router: newRouter(*r.routes.options)}
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	r.routes.hosts = append(r.routes.hosts, hr)
+	return hr.router
+}
+
+// matchHost returns the router for the request's host, if one was added with
+// Host, and the labels it captured.
+func (r *Router) matchHost(host string) (*Router, httprouter.Params) {
+	r.routes.mu.Lock()
+	hosts := r.routes.hosts
+	r.routes.mu.Unlock()
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(host), "."), ".")
+	for _, hr := range hosts {
+		if params, ok := hr.match(labels); ok {
+			return hr.router, params
+		}
+	}
+	return nil, nil
+}
+
+func (hr *hostRouter) match(labels []string) (httprouter.Params, bool) {
+	if len(labels) != len(hr.labels) {
+		return nil, false
+	}
+	var params httprouter.Params
+	for i, label := range hr.labels {
+		if strings.HasPrefix(label, "{") && strings.HasSuffix(label, "}") {
+			if labels[i] == "" {
+				return nil, false
+			}
+			params = append(params, httprouter.Param{Key: label[1 : len(label)-1], Value: labels[i]})
+		} else if label != labels[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (r *Router) serveHost(rw http.ResponseWriter, req *http.Request) bool {
+	hr, params := r.matchHost(req.Host)
+	if hr == nil {
+		return false
+	}
+	GetContext(req).HostParams = params
+	hr.ServeHTTP(rw, req)
+	return true
+}
@@ -0,0 +1,34 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, engine.J{"host": "fallback"}, http.StatusOK)
+	})
+	api := r.Host("api.example.com")
+	api.Use(record("api"))
+	api.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, engine.J{"host": "api"}, http.StatusOK)
+	})
+	tenants := r.Host("{tenant}.example.com")
+	tenants.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, engine.J{"tenant": engine.GetContext(req).HostParams.ByName("tenant")}, http.StatusOK)
+	})
+
+	c := enginetest.New(t, r)
+	c.Get("http://api.example.com:8080/").Do().AssertStatus(http.StatusOK).AssertJSONPath("host", "api").AssertHeader("X-Order", "api")
+	c.Get("http://Acme.example.com/").Do().AssertStatus(http.StatusOK).AssertJSONPath("tenant", "acme")
+	c.Get("http://a.b.example.com/").Do().AssertJSONPath("host", "fallback")
+	c.Get("http://example.org/").Do().AssertJSONPath("host", "fallback")
+	c.Get("http://acme.example.com/missing").Do().AssertStatus(http.StatusNotFound)
+	if len(api.Routes()) != 1 || len(r.Routes()) != 1 {
+		t.Errorf("host routes weren't kept apart: %d, %d", len(api.Routes()), len(r.Routes()))
+	}
+}
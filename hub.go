@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// ErrHubClosed is returned when joining a Hub that is shutting down.
+var ErrHubClosed = errors.New("engine: hub closed")
+
+// Hub groups WebSocket connections into rooms for broadcasting. Connections
+// leave all their rooms when they close. It tracks the connections in its
+// rooms, and every connection upgraded with a WebSocketConfig naming it.
+//
+// Hijacked connections aren't closed by http.Server.Shutdown, so register the
+// hub's shutdown with the server:
+//
+//	srv.RegisterOnShutdown(func() { hub.Shutdown(ctx) })
+type Hub struct {
+	mu      sync.Mutex
+	conns   map[*WebSocketConn]map[string]struct{}
+	rooms   map[string]map[*WebSocketConn]struct{}
+	closing bool
+	idle    chan struct{}
+}
+
+// NewHub creates an empty Hub.
+func NewHub() *Hub {
+	return &Hub{
+		conns: make(map[*WebSocketConn]map[string]struct{}),
+		rooms: make(map[string]map[*WebSocketConn]struct{}),
+	}
+}
+
+// Join adds c to room.
+func (h *Hub) Join(room string, c *WebSocketConn) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rooms, err := h.add(c)
+	if err != nil {
+		return err
+	}
+	rooms[room] = struct{}{}
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[*WebSocketConn]struct{})
+		h.rooms[room] = members
+	}
+	members[c] = struct{}{}
+	return nil
+}
+
+// track adds c to the hub's connections without joining a room.
+func (h *Hub) track(c *WebSocketConn) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.add(c)
+	return err
+}
+
+// add tracks c, returning its rooms.
+func (h *Hub) add(c *WebSocketConn) (map[string]struct{}, error) {
+	if h.closing {
+		return nil, ErrHubClosed
+	}
+	rooms, ok := h.conns[c]
+	if !ok {
+		if !c.addCloseHook(func() { h.remove(c) }) {
+			return nil, ErrWebSocketClosed
+		}
+		rooms = make(map[string]struct{})
+		h.conns[c] = rooms
+	}
+	return rooms, nil
+}
+
+func (h *Hub) isClosing() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.closing
+}
+
+// Leave removes c from room.
+func (h *Hub) Leave(room string, c *WebSocketConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rooms, ok := h.conns[c]; ok {
+		delete(rooms, room)
+	}
+	h.leave(room, c)
+}
+
+func (h *Hub) leave(room string, c *WebSocketConn) {
+	members := h.rooms[room]
+	delete(members, c)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+func (h *Hub) remove(c *WebSocketConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for room := range h.conns[c] {
+		h.leave(room, c)
+	}
+	delete(h.conns, c)
+	if h.closing && len(h.conns) == 0 {
+		close(h.idle)
+	}
+}
+
+// Presence returns the connections in room.
+func (h *Hub) Presence(room string) []*WebSocketConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	conns := make([]*WebSocketConn, 0, len(h.rooms[room]))
+	for c := range h.rooms[room] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Rooms returns the names of the rooms with at least one connection.
+func (h *Hub) Rooms() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rooms := make([]string, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// Broadcast sends a message to every connection in room. Connections that
+// can't be written to are closed.
+func (h *Hub) Broadcast(room string, messageType int, data []byte) {
+	var wg sync.WaitGroup
+	for _, c := range h.Presence(room) {
+		wg.Add(1)
+		go func(c *WebSocketConn) {
+			defer wg.Done()
+			if err := c.WriteMessage(messageType, data); err != nil {
+				c.close()
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// BroadcastJSON sends v as JSON to every connection in room.
+func (h *Hub) BroadcastJSON(room string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h.Broadcast(room, TextMessage, data)
+	return nil
+}
+
+// Shutdown starts the closing handshake with every connection the hub tracks
+// and waits for them to close, which happens once the peer acknowledges or
+// the handler returns. Handlers may still be running when it returns. If ctx
+// ends first the remaining connections are closed immediately and ctx's error
+// is returned.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	if !h.closing {
+		h.closing = true
+		h.idle = make(chan struct{})
+		if len(h.conns) == 0 {
+			close(h.idle)
+		}
+	}
+	conns := make([]*WebSocketConn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	idle := h.idle
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		go c.CloseWith(CloseGoingAway, "server shutting down")
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		for _, c := range conns {
+			c.close()
+		}
+		return ctx.Err()
+	}
+}
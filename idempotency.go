@@ -0,0 +1,335 @@
+package engine
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyKeyInUse is returned by IdempotencyStore.Lock when another
+// request holds the key.
+var ErrIdempotencyKeyInUse = errors.New("A request with this Idempotency-Key is in progress")
+
+// IdempotencyRecord is a completed response stored for an Idempotency-Key.
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request the response was for.
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// IdempotencyStore holds the responses to requests with an Idempotency-Key.
+// Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Lock reserves key for a request with fingerprint. It returns the record
+	// stored for key if there is one, ErrIdempotencyKeyInUse if another
+	// request holds the key, or nil and nil once the key is reserved.
+	Lock(key, fingerprint string) (*IdempotencyRecord, error)
+	// Save stores the response to the request holding key and releases it.
+	Save(key string, record *IdempotencyRecord) error
+	// Unlock releases key without storing a response, so it can be retried.
+	Unlock(key string) error
+}
+
+// IdempotencyConfig configures IdempotencyMiddleware.
+type IdempotencyConfig struct {
+	// Store holds responses. Defaults to a MemoryIdempotencyStore keeping
+	// them for 24 hours, within 64MB.
+	Store IdempotencyStore
+	// Methods are the methods keys are honoured for. Defaults to POST and
+	// PATCH.
+	Methods []string
+	// Required rejects requests with those methods but without a key with a
+	// 400.
+	Required bool
+	// Scope returns the namespace of the request's key, such as the
+	// authenticated user, so clients can't replay each other's responses.
+	// Defaults to a hash of the Authorization and Cookie headers.
+	//
+	// Set Scope if clients authenticate any other way, such as with client
+	// certificates. Otherwise they all share one namespace, and a client that
+	// reuses another's key is sent the other's response.
+	Scope func(req *http.Request) string
+	// MaxBodySize bounds the request bodies fingerprinted. Defaults to 1MB.
+	MaxBodySize int64
+	// MaxSize is the largest response body stored. Larger responses are sent
+	// but not stored. Defaults to 1MB.
+	MaxSize int
+}
+
+// IdempotencyMiddleware implements the Idempotency-Key header. The first
+// request with a key holds it while it runs, and its response is stored and
+// replayed, with an Idempotent-Replayed header, to retries with the same key.
+// Requests with a key in use get a 409, and those reusing a key for a
+// different method, path, query or body get a 422. 5xx responses aren't
+// stored, so the request can be retried.
+func IdempotencyMiddleware(config *IdempotencyConfig) MiddlewareFunc {
+	c := IdempotencyConfig{}
+	if config != nil {
+		c = *config
+	}
+	if c.Store == nil {
+		c.Store = NewMemoryIdempotencyStore(24*time.Hour, 0)
+	}
+	if c.Scope == nil {
+		c.Scope = credentialsScope
+	}
+	if c.Methods == nil {
+		c.Methods = []string{"POST", "PATCH"}
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 1 << 20
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = 1 << 20
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if !containsMethod(c.Methods, req.Method) {
+				next.ServeHTTP(rw, req)
+				return
+			}
+			key := req.Header.Get("Idempotency-Key")
+			switch {
+			case key == "" && c.Required:
+				RenderError(rw, req, errors.New("Idempotency-Key header is required"), http.StatusBadRequest)
+				return
+			case key == "":
+				next.ServeHTTP(rw, req)
+				return
+			case len(key) > 255:
+				RenderError(rw, req, errors.New("Idempotency-Key must be at most 255 characters"), http.StatusBadRequest)
+				return
+			}
+			key = c.Scope(req) + ":" + key
+
+			fingerprint, err := idempotencyFingerprint(req, c.MaxBodySize)
+			if err == ErrRequestTooLarge {
+				RenderError(rw, req, err, http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				RenderError(rw, req, err, http.StatusBadRequest)
+				return
+			}
+			record, err := c.Store.Lock(key, fingerprint)
+			switch {
+			case err == ErrIdempotencyKeyInUse:
+				RenderError(rw, req, err, http.StatusConflict)
+				return
+			case err != nil:
+				RenderError(rw, req, err, http.StatusInternalServerError)
+				return
+			case record != nil && record.Fingerprint != fingerprint:
+				RenderError(rw, req, errors.New("Idempotency-Key was used for a different request"), http.StatusUnprocessableEntity)
+				return
+			case record != nil:
+				copyHeader(rw.Header(), record.Header)
+				rw.Header().Set("Idempotent-Replayed", "true")
+				rw.WriteHeader(record.Status)
+				rw.Write(record.Body)
+				return
+			}
+
+			ir := &idempotencyRecorder{ResponseWriter: NewResponseWriter(rw), before: rw.Header().Clone(), limit: c.MaxSize}
+			saved := false
+			defer func() {
+				if !saved {
+					c.Store.Unlock(key)
+				}
+			}()
+			next.ServeHTTP(ir, req)
+			if ir.Status() >= 500 || ir.overflow || ir.Status() == http.StatusSwitchingProtocols {
+				return
+			}
+			ir.snapshot()
+			saved = c.Store.Save(key, &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Status:      ir.Status(),
+				Header:      ir.header,
+				Body:        ir.body.Bytes(),
+			}) == nil
+		})
+	}
+}
+
+// credentialsScope is the default IdempotencyConfig.Scope, which hashes the
+// headers credentialed checks.
+func credentialsScope(req *http.Request) string {
+	if !credentialed(req) {
+		return ""
+	}
+	h := sha256.Sum256([]byte(req.Header.Get("Authorization") + "\n" + strings.Join(req.Header["Cookie"], "; ")))
+	return hex.EncodeToString(h[:16])
+}
+
+// idempotencyFingerprint hashes the request's method, path, query and body,
+// leaving the body to be read again.
+func idempotencyFingerprint(req *http.Request, maxBodySize int64) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, req.Method+" "+req.URL.RequestURI()+"\n")
+	ctx := GetContext(req)
+	if ctx.ReadCloser != nil && ctx.ReadCloser != http.NoBody {
+		data, err := io.ReadAll(io.LimitReader(ctx.ReadCloser, maxBodySize+1))
+		if err != nil {
+			return "", err
+		}
+		if int64(len(data)) > maxBodySize {
+			return "", ErrRequestTooLarge
+		}
+		ctx.ReadCloser = io.NopCloser(bytes.NewReader(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// idempotencyRecorder writes the response through and records it, up to
+// limit bytes of body. Only the headers set after the middleware, which
+// before holds, are recorded.
+type idempotencyRecorder struct {
+	*ResponseWriter
+	before   http.Header
+	header   http.Header
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// snapshot records the headers set since the middleware was called.
+func (w *idempotencyRecorder) snapshot() {
+	if w.header != nil {
+		return
+	}
+	w.header = make(http.Header)
+	for k, v := range w.Header() {
+		if !reflect.DeepEqual(w.before[k], v) {
+			w.header[k] = append([]string(nil), v...)
+		}
+	}
+}
+
+func (w *idempotencyRecorder) WriteHeader(code int) {
+	w.snapshot()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.snapshot()
+	if w.body.Len()+len(b) > w.limit {
+		w.overflow = true
+	} else if !w.overflow {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Records, and keys
+// held by requests that never complete, expire after its TTL. Once the keys
+// and records it holds exceed its size, the oldest records are evicted early.
+type MemoryIdempotencyStore struct {
+	ttl     time.Duration
+	maxSize int64
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+type idempotencyEntry struct {
+	key     string
+	record  *IdempotencyRecord
+	expires time.Time
+	size    int64
+}
+
+// NewMemoryIdempotencyStore creates a MemoryIdempotencyStore keeping records
+// for ttl, within maxSize bytes. A maxSize of zero defaults to 64MB.
+func NewMemoryIdempotencyStore(ttl time.Duration, maxSize int64) *MemoryIdempotencyStore {
+	if maxSize == 0 {
+		maxSize = 64 << 20
+	}
+	return &MemoryIdempotencyStore{ttl: ttl, maxSize: maxSize, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (s *MemoryIdempotencyStore) Lock(key, fingerprint string) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.expire(now)
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*idempotencyEntry)
+		if e.record == nil {
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return e.record, nil
+	}
+	s.put(&idempotencyEntry{key: key, expires: now.Add(s.ttl), size: int64(len(key))})
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(key string, record *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := int64(len(key) + len(record.Fingerprint) + len(record.Body))
+	for k, v := range record.Header {
+		size += int64(len(k))
+		for _, value := range v {
+			size += int64(len(value))
+		}
+	}
+	s.put(&idempotencyEntry{key: key, record: record, expires: time.Now().Add(s.ttl), size: size})
+	s.evict()
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Unlock(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok && el.Value.(*idempotencyEntry).record == nil {
+		s.remove(el)
+	}
+	return nil
+}
+
+// put adds e, replacing any entry for its key, as the newest entry. As every
+// entry has the same TTL, entries are ordered by when they expire too.
+func (s *MemoryIdempotencyStore) put(e *idempotencyEntry) {
+	if el, ok := s.entries[e.key]; ok {
+		s.remove(el)
+	}
+	s.entries[e.key] = s.order.PushBack(e)
+	s.size += e.size
+}
+
+func (s *MemoryIdempotencyStore) remove(el *list.Element) {
+	e := s.order.Remove(el).(*idempotencyEntry)
+	delete(s.entries, e.key)
+	s.size -= e.size
+}
+
+// expire removes the entries that have expired.
+func (s *MemoryIdempotencyStore) expire(now time.Time) {
+	for el := s.order.Front(); el != nil && !now.Before(el.Value.(*idempotencyEntry).expires); el = s.order.Front() {
+		s.remove(el)
+	}
+}
+
+// evict removes the oldest records until the store is within its size. Keys
+// held by requests in progress are kept.
+func (s *MemoryIdempotencyStore) evict() {
+	for el := s.order.Front(); el != nil && s.size > s.maxSize; {
+		next := el.Next()
+		if el.Value.(*idempotencyEntry).record != nil {
+			s.remove(el)
+		}
+		el = next
+	}
+}
@@ -0,0 +1,168 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	var orders int32
+	started, release := make(chan struct{}), make(chan struct{})
+	r := engine.NewRouter()
+	r.Use(engine.IdempotencyMiddleware(&engine.IdempotencyConfig{Store: engine.NewMemoryIdempotencyStore(time.Minute, 0)}))
+	r.Post("/orders", func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("slow") != "" {
+			close(started)
+			<-release
+		}
+		if req.URL.Query().Get("fail") != "" {
+			engine.RenderError(rw, req, nil, http.StatusServiceUnavailable)
+			return
+		}
+		n := atomic.AddInt32(&orders, 1)
+		rw.Header().Set("Location", "/orders/"+strconv.Itoa(int(n)))
+		engine.JSON(rw, engine.J{"id": n}, http.StatusCreated)
+	})
+	c := enginetest.New(t, r)
+	order := func(key string) *enginetest.Request {
+		return c.Post("/orders").Header("Idempotency-Key", key).Body("application/json", strings.NewReader(`{"sku":"a"}`))
+	}
+
+	order("1").Do().AssertStatus(http.StatusCreated).AssertHeader("Location", "/orders/1").AssertHeader("Idempotent-Replayed", "")
+	order("1").Do().
+		AssertStatus(http.StatusCreated).
+		AssertHeader("Location", "/orders/1").
+		AssertHeader("Idempotent-Replayed", "true").
+		AssertJSONPath("id", 1)
+	c.Post("/orders").Header("Idempotency-Key", "1").Body("application/json", strings.NewReader(`{"sku":"b"}`)).Do().
+		AssertStatus(http.StatusUnprocessableEntity)
+	c.Post("/orders").Do().AssertStatus(http.StatusCreated).AssertHeader("Location", "/orders/2")
+
+	c.Post("/orders?fail=1").Header("Idempotency-Key", "2").Do().AssertStatus(http.StatusServiceUnavailable)
+	c.Post("/orders").Header("Idempotency-Key", "2").Do().AssertStatus(http.StatusCreated)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Post("/orders?slow=1").Header("Idempotency-Key", "3").Do().AssertStatus(http.StatusCreated)
+	}()
+	<-started
+	c.Post("/orders?slow=1").Header("Idempotency-Key", "3").Do().AssertStatus(http.StatusConflict)
+	close(release)
+	<-done
+	if n := atomic.LoadInt32(&orders); n != 4 {
+		t.Errorf("orders = %d, want 4", n)
+	}
+}
+
+func TestIdempotencyRequired(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(engine.IdempotencyMiddleware(&engine.IdempotencyConfig{Required: true}))
+	r.Post("/payments", writeMethod)
+	r.Get("/payments", writeMethod)
+	c := enginetest.New(t, r)
+	c.Post("/payments").Do().AssertStatus(http.StatusBadRequest)
+	c.Get("/payments").Do().AssertStatus(http.StatusOK)
+}
+
+// counter returns a handler responding with how often it has been called,
+// with a body of at least size bytes.
+func counter(calls *int32, size int) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		rw.Write([]byte(strconv.Itoa(int(n)) + strings.Repeat(" ", size)))
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	var calls, tenantCalls int32
+	r := engine.NewRouter()
+	r.Post("/default", counter(&calls, 0), engine.IdempotencyMiddleware(nil))
+	r.Post("/tenant", counter(&tenantCalls, 0), engine.IdempotencyMiddleware(&engine.IdempotencyConfig{
+		Scope: func(req *http.Request) string { return req.Header.Get("X-Tenant") },
+	}))
+	c := enginetest.New(t, r)
+
+	// By default keys are scoped to the client's credentials.
+	c.Post("/default").Header("Idempotency-Key", "k").Header("Authorization", "Bearer alice").Do().AssertBody("1")
+	c.Post("/default").Header("Idempotency-Key", "k").Header("Authorization", "Bearer alice").Do().
+		AssertHeader("Idempotent-Replayed", "true").AssertBody("1")
+	c.Post("/default").Header("Idempotency-Key", "k").Header("Authorization", "Bearer bob").Do().
+		AssertHeader("Idempotent-Replayed", "").AssertBody("2")
+	c.Post("/default").Header("Idempotency-Key", "k").Do().AssertBody("3")
+	c.Post("/default").Header("Idempotency-Key", "k").Header("Cookie", "session=alice").Do().AssertBody("4")
+	c.Post("/default").Header("Idempotency-Key", "k").Header("Cookie", "session=bob").Do().
+		AssertHeader("Idempotent-Replayed", "").AssertBody("5")
+	c.Post("/default").Header("Idempotency-Key", "k").Header("Cookie", "session=alice").Do().
+		AssertHeader("Idempotent-Replayed", "true").AssertBody("4")
+
+	c.Post("/tenant").Header("Idempotency-Key", "k").Header("X-Tenant", "acme").Do().AssertBody("1")
+	c.Post("/tenant").Header("Idempotency-Key", "k").Header("X-Tenant", "globex").Do().AssertBody("2")
+	c.Post("/tenant").Header("Idempotency-Key", "k").Header("X-Tenant", "acme").Header("Authorization", "Bearer bob").Do().
+		AssertHeader("Idempotent-Replayed", "true").AssertBody("1")
+}
+
+func TestIdempotencyMaxSize(t *testing.T) {
+	var calls int32
+	r := engine.NewRouter()
+	r.Use(engine.IdempotencyMiddleware(&engine.IdempotencyConfig{MaxSize: 16}))
+	r.Post("/small", counter(&calls, 0))
+	r.Post("/large", counter(&calls, 16))
+	c := enginetest.New(t, r)
+
+	c.Post("/small").Header("Idempotency-Key", "a").Do().AssertBody("1")
+	c.Post("/small").Header("Idempotency-Key", "a").Do().AssertHeader("Idempotent-Replayed", "true").AssertBody("1")
+
+	// Responses too large to store are sent, and the key released.
+	c.Post("/large").Header("Idempotency-Key", "b").Do().AssertBody("2" + strings.Repeat(" ", 16))
+	c.Post("/large").Header("Idempotency-Key", "b").Do().AssertHeader("Idempotent-Replayed", "").AssertBody("3" + strings.Repeat(" ", 16))
+}
+
+func TestMemoryIdempotencyStoreTTL(t *testing.T) {
+	var calls int32
+	r := engine.NewRouter()
+	r.Use(engine.IdempotencyMiddleware(&engine.IdempotencyConfig{Store: engine.NewMemoryIdempotencyStore(20*time.Millisecond, 0)}))
+	r.Post("/orders", counter(&calls, 0))
+	c := enginetest.New(t, r)
+
+	c.Post("/orders").Header("Idempotency-Key", "a").Do().AssertBody("1")
+	c.Post("/orders").Header("Idempotency-Key", "a").Do().AssertBody("1")
+	time.Sleep(30 * time.Millisecond)
+	c.Post("/orders").Header("Idempotency-Key", "a").Do().AssertHeader("Idempotent-Replayed", "").AssertBody("2")
+}
+
+func TestMemoryIdempotencyStoreEviction(t *testing.T) {
+	store := engine.NewMemoryIdempotencyStore(time.Minute, 100)
+	record := func(body string) *engine.IdempotencyRecord {
+		return &engine.IdempotencyRecord{Fingerprint: "f", Status: http.StatusOK, Body: []byte(body)}
+	}
+	body := strings.Repeat("x", 40)
+	for _, key := range []string{"a", "b"} {
+		store.Lock(key, "f")
+		store.Save(key, record(body))
+	}
+	// A held key isn't evicted, however old.
+	if _, err := store.Lock("held", "f"); err != nil {
+		t.Fatal(err)
+	}
+	store.Lock("c", "f")
+	store.Save("c", record(body))
+
+	if rec, _ := store.Lock("a", "f"); rec != nil {
+		t.Error("oldest record wasn't evicted")
+	}
+	for _, key := range []string{"b", "c"} {
+		if rec, err := store.Lock(key, "f"); rec == nil || err != nil {
+			t.Errorf("record %s = %v, %v; want it kept", key, rec, err)
+		}
+	}
+	if _, err := store.Lock("held", "f"); err != engine.ErrIdempotencyKeyInUse {
+		t.Errorf("held key: error = %v, want ErrIdempotencyKeyInUse", err)
+	}
+}
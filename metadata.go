@@ -6,6 +6,7 @@ import (
 	"github.com/satori/go.uuid"
 	"net/http"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -19,11 +20,85 @@ type RequestMetadata struct {
 	Size      int
 	Latency   time.Duration
 	StartTime time.Time
+	TimedOut  bool
+	Stream    bool
+
+	debug  bool
+	quiet  bool
+	logger *log.Logger
 }
 
 // Logger returns a logger that logs with the request fields
 func (r *RequestMetadata) Logger() *log.Entry {
-	return log.WithFields(r.fields())
+	logger := r.baseLogger()
+	if r.debug {
+		logger = debugLogger(logger)
+	}
+	return logger.WithFields(r.fields())
+}
+
+func (r *RequestMetadata) baseLogger() *log.Logger {
+	if r.logger == nil {
+		return log.StandardLogger()
+	}
+	return r.logger
+}
+
+// debugLoggers holds the debug level logger built for each base logger.
+var debugLoggers sync.Map
+
+// debugLogger returns a logger like logger but at debug level, for routes
+// with debug logging turned on. It's built once per logger, and writes,
+// formats and fires hooks with whatever logger has at the time. Its writes are
+// serialised with each other but not with logger's, so logger's Out must be
+// safe for concurrent writes of whole entries, as files are.
+func debugLogger(logger *log.Logger) *log.Logger {
+	if d, ok := debugLoggers.Load(logger); ok {
+		return d.(*log.Logger)
+	}
+	hooks := log.LevelHooks{}
+	hooks.Add(baseHooks{logger})
+	d, _ := debugLoggers.LoadOrStore(logger, &log.Logger{
+		Out:       &baseWriter{logger: logger},
+		Formatter: baseFormatter{logger},
+		Hooks:     hooks,
+		Level:     log.DebugLevel,
+	})
+	return d.(*log.Logger)
+}
+
+// baseWriter writes to its logger's output.
+type baseWriter struct {
+	mu     sync.Mutex
+	logger *log.Logger
+}
+
+func (w *baseWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.logger.Out.Write(p)
+}
+
+// baseFormatter formats entries with its logger's formatter.
+type baseFormatter struct {
+	logger *log.Logger
+}
+
+func (f baseFormatter) Format(entry *log.Entry) ([]byte, error) {
+	return f.logger.Formatter.Format(entry)
+}
+
+// baseHooks fires its logger's hooks.
+type baseHooks struct {
+	logger *log.Logger
+}
+
+func (h baseHooks) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h baseHooks) Fire(entry *log.Entry) error {
+	return h.logger.Hooks.Fire(entry.Level, entry)
 }
 
 func (r *RequestMetadata) fields() log.Fields {
@@ -40,14 +115,30 @@ const metadataCtxKey key = 0
 // M is middleware that adds metadata to each requests and logs
 func MetadataMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Handlers called from within another handler, such as the NotFound
+		// handler, are already covered by the outer request's metadata.
+		if _, ok := GetMetadata(GetContext(req)); ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
 
 		start := time.Now().UTC()
 
+		options := GetContext(req).options()
 		metadata := &RequestMetadata{
-			RequestID: uuid.NewV4().String(),
 			StartTime: start,
 			Method:    req.Method,
 			Path:      req.URL.Path,
+			logger:    options.logger,
+		}
+		if options.requestID != nil {
+			metadata.RequestID = options.requestID()
+		} else {
+			metadata.RequestID = uuid.NewV4().String()
+		}
+		if route := GetContext(req).Route; route != nil {
+			metadata.debug = route.debug.Load()
+			metadata.quiet = route.quiet.Load()
 		}
 		req.Header.Set("X-Request-Id", metadata.RequestID)
 		rw.Header().Set("Request-Id", metadata.RequestID)
@@ -72,7 +163,7 @@ func MetadataMiddleware(next http.Handler) http.Handler {
 				stackSize := runtime.Stack(buf, true)
 				metadata.Logger().Error(err)
 				metadata.Logger().Errorf("%s", string(buf[0:stackSize]))
-				JSONError(rw, errors.New("Ooops. Something went wrong on our end"), http.StatusInternalServerError)
+				RenderError(rw, req, errors.New("Ooops. Something went wrong on our end"), http.StatusInternalServerError)
 			}
 
 			resp := rw.(*ResponseWriter)
@@ -80,14 +171,19 @@ func MetadataMiddleware(next http.Handler) http.Handler {
 			metadata.Size = resp.Length()
 			statusColor := ColourForStatus(metadata.Status)
 			metadata.Latency = time.Since(start)
+			if metadata.quiet && metadata.Status < 500 {
+				return
+			}
 
-			log.WithFields(metadata.fields()).WithFields(log.Fields{
+			metadata.baseLogger().WithFields(metadata.fields()).WithFields(log.Fields{
 				"remote_ip": metadata.IP,
 				"method":    metadata.Method,
 				"path":      metadata.Path,
 				"size":      metadata.Size,
 				"latency":   metadata.Latency,
 				"status":    metadata.Status,
+				"timed_out": metadata.TimedOut,
+				"stream":    metadata.Stream,
 			}).Printf("%s\t| %s | %s%d%s | %v | %s", metadata.Path, metadata.Method, statusColor, metadata.Status, ResetColour, metadata.Latency, HumanSize(metadata.Size))
 		}()
 
@@ -96,6 +192,13 @@ func MetadataMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// SetMetadata stores md as the request's metadata. MetadataMiddleware leaves
+// requests that already have metadata alone, so this is mostly useful in
+// tests.
+func SetMetadata(ctx *Context, md *RequestMetadata) {
+	ctx.Set(metadataCtxKey, md)
+}
+
 // FromContext extracts the metadata from the request
 func GetMetadata(ctx *Context) (*RequestMetadata, bool) {
 	md, ok := ctx.Value(metadataCtxKey).(*RequestMetadata)
@@ -0,0 +1,121 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"testing"
+)
+
+func TestMetadataRequestID(t *testing.T) {
+	var seen string
+	r := engine.NewRouter()
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		md, ok := engine.GetMetadata(engine.GetContext(req))
+		if !ok {
+			t.Fatalf("no metadata in handler")
+		}
+		seen = md.RequestID
+		if got := req.Header.Get("X-Request-Id"); got != md.RequestID {
+			t.Errorf("X-Request-Id = %q, want %q", got, md.RequestID)
+		}
+	})
+	c := enginetest.New(t, r)
+	res := c.Get("/").Do().AssertStatus(http.StatusOK)
+	if seen == "" || res.Header().Get("Request-Id") != seen {
+		t.Errorf("Request-Id = %q, want %q", res.Header().Get("Request-Id"), seen)
+	}
+
+	first := seen
+	c.Get("/").Do()
+	if seen == first {
+		t.Errorf("request IDs aren't unique")
+	}
+}
+
+func TestMetadataIP(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		md, _ := engine.GetMetadata(engine.GetContext(req))
+		rw.Write([]byte(md.IP))
+	})
+	c := enginetest.New(t, r)
+	c.Get("/").Do().AssertBody("192.0.2.1:1234")
+	c.Get("/").Header("X-Forwarded-For", "198.51.100.1").Do().AssertBody("198.51.100.1")
+	c.Get("/").Header("X-Forwarded-For", "198.51.100.1").Header("X-Real-IP", "203.0.113.1").Do().AssertBody("203.0.113.1")
+}
+
+func TestMetadataAccessLog(t *testing.T) {
+	logs := enginetest.CaptureLogs(t)
+	r := engine.NewRouter()
+	r.Post("/users", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("created"))
+	})
+	res := enginetest.New(t, r).Post("/users").Do()
+
+	entry, ok := logs.AccessLog("POST", "/users")
+	if !ok {
+		t.Fatalf("no access log entry in %v", logs.Entries())
+	}
+	if entry.Fields["status"] != http.StatusCreated {
+		t.Errorf("status = %v, want 201", entry.Fields["status"])
+	}
+	if entry.Fields["size"] != 7 {
+		t.Errorf("size = %v, want 7", entry.Fields["size"])
+	}
+	if entry.Fields["request_id"] != res.Header().Get("Request-Id") {
+		t.Errorf("request_id = %v, want %v", entry.Fields["request_id"], res.Header().Get("Request-Id"))
+	}
+	if entry.Fields["timed_out"] != false || entry.Fields["stream"] != false {
+		t.Errorf("timed_out = %v, stream = %v", entry.Fields["timed_out"], entry.Fields["stream"])
+	}
+
+	md, _ := engine.GetMetadata(engine.GetContext(res.Request))
+	if md.Status != http.StatusCreated || md.Size != 7 || md.Latency <= 0 {
+		t.Errorf("metadata = %+v", md)
+	}
+}
+
+func TestMetadataNotFoundLoggedOnce(t *testing.T) {
+	logs := enginetest.CaptureLogs(t)
+	r := engine.NewRouter()
+	enginetest.New(t, r).Get("/missing").Do().AssertStatus(http.StatusNotFound)
+	if n := len(logs.Entries()); n != 1 {
+		t.Errorf("logged %d entries, want 1", n)
+	}
+	if entry, _ := logs.AccessLog("GET", "/missing"); entry.Fields["status"] != http.StatusNotFound {
+		t.Errorf("status = %v, want 404", entry.Fields["status"])
+	}
+}
+
+func TestMetadataRecoversPanics(t *testing.T) {
+	logs := enginetest.CaptureLogs(t)
+	r := engine.NewRouter()
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+	enginetest.New(t, r).Get("/").Do().
+		AssertStatus(http.StatusInternalServerError).
+		AssertJSONPath("message", "Ooops. Something went wrong on our end")
+	if !logs.Contains("boom") {
+		t.Errorf("panic wasn't logged")
+	}
+	if entry, _ := logs.AccessLog("GET", "/"); entry.Fields["status"] != http.StatusInternalServerError {
+		t.Errorf("status = %v, want 500", entry.Fields["status"])
+	}
+}
+
+func TestMetadataPreset(t *testing.T) {
+	logs := enginetest.CaptureLogs(t)
+	r := engine.NewRouter()
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		md, _ := engine.GetMetadata(engine.GetContext(req))
+		rw.Write([]byte(md.RequestID))
+	})
+	fake := enginetest.FakeMetadata()
+	enginetest.New(t, r).Get("/").Metadata(fake).Do().AssertBody(fake.RequestID)
+	if len(logs.Entries()) != 0 {
+		t.Errorf("request with preset metadata was logged: %v", logs.Entries())
+	}
+}
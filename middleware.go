@@ -3,6 +3,7 @@ package engine
 import (
 	"fmt"
 	"net/http"
+	"path"
 	"strings"
 )
 
@@ -55,3 +56,96 @@ func CORSMiddleware(config *CORSConfig) MiddlewareFunc {
 		})
 	}
 }
+
+// HandlerMiddleware returns middleware that calls handler and then, unless
+// handler wrote a response, the next handler. It suits guards such as
+//
+//	r.Use(engine.HandlerMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+//		if req.Header.Get("Authorization") == "" {
+//			engine.RenderError(rw, req, nil, http.StatusUnauthorized)
+//		}
+//	})))
+func HandlerMiddleware(handler http.Handler) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			gw := &guardResponseWriter{ResponseWriter: rw}
+			handler.ServeHTTP(gw, req)
+			if !gw.written {
+				next.ServeHTTP(rw, req)
+			}
+		})
+	}
+}
+
+// guardResponseWriter records whether a response was written.
+type guardResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *guardResponseWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *guardResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *guardResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Chain combines middleware into one, the first being outermost.
+func Chain(middleware ...MiddlewareFunc) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		return next
+	}
+}
+
+// When applies middleware only to requests for which predicate is true.
+func When(predicate func(*http.Request) bool, middleware MiddlewareFunc) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		wrapped := middleware(next)
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if predicate(req) {
+				wrapped.ServeHTTP(rw, req)
+			} else {
+				next.ServeHTTP(rw, req)
+			}
+		})
+	}
+}
+
+// Unless applies middleware except to requests whose path matches pattern.
+// Patterns are those of path.Match, and one ending in /** also matches every
+// path below it, e.g. "/static/**" or "/users/*/avatar".
+func Unless(pattern string, middleware MiddlewareFunc) MiddlewareFunc {
+	if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+		panic("engine: bad pattern " + pattern + ": " + err.Error())
+	}
+	return When(func(req *http.Request) bool {
+		return !matchGlob(pattern, req.URL.Path)
+	}, middleware)
+}
+
+// matchGlob reports whether p matches pattern, as in Unless.
+func matchGlob(pattern, p string) bool {
+	if !strings.HasSuffix(pattern, "/**") {
+		ok, _ := path.Match(pattern, p)
+		return ok
+	}
+	pattern = strings.TrimSuffix(pattern, "/**")
+	n := strings.Count(pattern, "/")
+	segments := strings.SplitAfter(p, "/")
+	if len(segments) <= n {
+		ok, _ := path.Match(pattern, p)
+		return ok
+	}
+	ok, _ := path.Match(pattern, strings.TrimSuffix(strings.Join(segments[:n+1], ""), "/"))
+	return ok
+}
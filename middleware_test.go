@@ -0,0 +1,170 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCORSAcceptAll(t *testing.T) {
+	called := false
+	r := engine.NewRouter()
+	r.Use(engine.CORSAcceptAll)
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+	r.Options("/", func(rw http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+	c := enginetest.New(t, r)
+
+	c.Options("/").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Access-Control-Allow-Origin", "*").
+		AssertHeader("Access-Control-Allow-Methods", "*").
+		AssertHeader("Access-Control-Allow-Headers", "X-Requested-With, Content-Type, Authorization")
+	if called {
+		t.Errorf("preflight request reached the handler")
+	}
+
+	c.Get("/").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Access-Control-Allow-Origin", "*").
+		AssertHeader("Access-Control-Allow-Headers", "*")
+	if !called {
+		t.Errorf("request didn't reach the handler")
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	config := &engine.CORSConfig{
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+	}
+	called := false
+	r := engine.NewRouter()
+	r.Use(engine.CORSMiddleware(config))
+	r.Post("/", func(rw http.ResponseWriter, req *http.Request) {
+		called = true
+		rw.WriteHeader(http.StatusCreated)
+	})
+	r.Options("/", func(rw http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+	c := enginetest.New(t, r)
+
+	c.Options("/").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Access-Control-Allow-Methods", "GET, POST").
+		AssertHeader("Access-Control-Allow-Origin", "https://example.com").
+		AssertHeader("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	if called {
+		t.Errorf("preflight request reached the handler")
+	}
+
+	c.Post("/").Do().
+		AssertStatus(http.StatusCreated).
+		AssertHeader("Access-Control-Allow-Origin", "https://example.com")
+	if !called {
+		t.Errorf("request didn't reach the handler")
+	}
+}
+
+func TestCORSAllowAllConfig(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(engine.CORSMiddleware(engine.AllowAllConfig))
+	r.Get("/", writeMethod)
+	enginetest.New(t, r).Get("/").Do().
+		AssertHeader("Access-Control-Allow-Origin", "*").
+		AssertHeader("Access-Control-Allow-Headers", "Authorization, X-Requested-With")
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	r := engine.NewRouter()
+	r.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			engine.RenderError(rw, req, nil, http.StatusUnauthorized)
+		}
+	}))
+	called := false
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+	c := enginetest.New(t, r)
+
+	c.Get("/").Do().AssertStatus(http.StatusUnauthorized)
+	if called {
+		t.Error("handler called after the guard wrote a response")
+	}
+	c.Get("/").Header("Authorization", "token").Do().AssertStatus(http.StatusOK)
+	if !called {
+		t.Error("handler not called")
+	}
+}
+
+func TestUseAfterRoutes(t *testing.T) {
+	r := engine.NewRouter()
+	api := r.SubRouter("/api", record("api"))
+	api.Get("/users", writeMethod)
+	c := enginetest.New(t, r)
+	c.Get("/api/users").Do().AssertHeader("X-Order", "api")
+
+	r.Use(record("root"))
+	api.Use(record("late"))
+	res := c.Get("/api/users").Do().AssertStatus(http.StatusOK)
+	if got, want := res.Header()["X-Order"], []string{"root", "api", "late"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("middleware order = %v, want %v", got, want)
+	}
+}
+
+func TestUseBuildsMiddlewareOnce(t *testing.T) {
+	built, served := 0, 0
+	counter := func(next http.Handler) http.Handler {
+		built++
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			served++
+			next.ServeHTTP(rw, req)
+		})
+	}
+	r := engine.NewRouter()
+	r.Use(counter)
+	r.Get("/", writeMethod)
+	c := enginetest.New(t, r)
+	c.Get("/").Do().AssertStatus(http.StatusOK)
+	r.Use(record("late"))
+	c.Get("/").Do().AssertStatus(http.StatusOK).AssertHeader("X-Order", "late")
+	c.Get("/").Do().AssertStatus(http.StatusOK)
+	if built != 1 || served != 3 {
+		t.Errorf("middleware built %d times and served %d requests, want 1 and 3", built, served)
+	}
+}
+
+func TestMiddlewareCombinators(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(
+		engine.Chain(record("a"), record("b")),
+		engine.When(func(req *http.Request) bool { return req.URL.Query().Get("debug") != "" }, record("debug")),
+		engine.Unless("/static/**", record("auth")),
+	)
+	r.Get("/users", writeMethod)
+	r.Get("/static/*path", writeMethod)
+	c := enginetest.New(t, r)
+
+	cases := []struct {
+		path string
+		want []string
+	}{
+		{"/users", []string{"a", "b", "auth"}},
+		{"/users?debug=1", []string{"a", "b", "debug", "auth"}},
+		{"/static/css/site.css", []string{"a", "b"}},
+	}
+	for _, tc := range cases {
+		res := c.Get(tc.path).Do().AssertStatus(http.StatusOK)
+		if got := res.Header()["X-Order"]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GET %s middleware = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
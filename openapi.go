@@ -0,0 +1,519 @@
+package engine
+
+import (
+	"encoding"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpenAPIConfig configures the OpenAPI document produced by a Router.
+type OpenAPIConfig struct {
+	// Title and Version describe the API. They default to "API" and "0.0.0".
+	Title       string
+	Version     string
+	Description string
+	// Servers are the base URLs the API is served from.
+	Servers []string
+	// Path is where ServeOpenAPI serves the document. Defaults to /openapi.json.
+	Path string
+	// DocsPath, if set, is where ServeOpenAPI serves a page rendering the
+	// document with Swagger UI.
+	DocsPath string
+	// DocsAssetsURL is where the page loads swagger-ui.css and
+	// swagger-ui-bundle.js from. Defaults to the unpkg.com CDN; serve a copy
+	// of swagger-ui-dist yourself, e.g. with Router.Static, to avoid relying
+	// on a third party.
+	DocsAssetsURL string
+}
+
+// defaultDocsAssetsURL serves Swagger UI's assets from a CDN.
+const defaultDocsAssetsURL = "https://unpkg.com/swagger-ui-dist@5"
+
+var openAPIMethods = map[string]bool{
+	"GET": true, "PUT": true, "POST": true, "DELETE": true,
+	"OPTIONS": true, "HEAD": true, "PATCH": true, "TRACE": true,
+}
+
+// OpenAPI returns an OpenAPI 3.1 document describing the routes registered on
+// the router and its sub routers. Paths and path parameters come from the
+// routes themselves, and everything else from their Route descriptions.
+func (r *Router) OpenAPI(config *OpenAPIConfig) J {
+	if config == nil {
+		config = &OpenAPIConfig{}
+	}
+	g := &schemaGenerator{schemas: J{}, names: make(map[reflect.Type]string), used: make(map[string]bool)}
+	paths := J{}
+	for _, rt := range r.Routes() {
+		documented, ok := rt.documentedPath()
+		if !ok {
+			continue
+		}
+		op := g.operation(rt)
+		if op == nil {
+			continue
+		}
+		p, _ := openAPIPath(documented)
+		item, ok := paths[p].(J)
+		if !ok {
+			item = J{}
+			paths[p] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+
+	info := J{"title": config.Title, "version": config.Version}
+	if config.Title == "" {
+		info["title"] = "API"
+	}
+	if config.Version == "" {
+		info["version"] = "0.0.0"
+	}
+	if config.Description != "" {
+		info["description"] = config.Description
+	}
+	doc := J{"openapi": "3.1.0", "info": info, "paths": paths}
+	if len(config.Servers) > 0 {
+		servers := make([]J, len(config.Servers))
+		for i, url := range config.Servers {
+			servers[i] = J{"url": url}
+		}
+		doc["servers"] = servers
+	}
+
+	components := J{}
+	if len(g.schemas) > 0 {
+		components["schemas"] = g.schemas
+	}
+	r.routes.mu.Lock()
+	if len(r.routes.schemes) > 0 {
+		schemes := J{}
+		for name, scheme := range r.routes.schemes {
+			schemes[name] = scheme
+		}
+		components["securitySchemes"] = schemes
+	}
+	r.routes.mu.Unlock()
+	if len(components) > 0 {
+		doc["components"] = components
+	}
+	return doc
+}
+
+// ServeOpenAPI serves the router's OpenAPI document, and optionally a docs
+// page, as configured. The document is generated on its first request, so
+// routes registered after calling ServeOpenAPI are included.
+func (r *Router) ServeOpenAPI(config *OpenAPIConfig) {
+	if config == nil {
+		config = &OpenAPIConfig{}
+	}
+	specPath := config.Path
+	if specPath == "" {
+		specPath = "/openapi.json"
+	}
+
+	var once sync.Once
+	var doc []byte
+	var err error
+	r.Get(specPath, func(rw http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			doc, err = json.Marshal(r.OpenAPI(config))
+		})
+		if err != nil {
+			JSONError(rw, err, http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.Write(doc)
+	}).Hidden()
+
+	if config.DocsPath == "" {
+		return
+	}
+	page := struct{ Title, SpecURL, AssetsURL string }{config.Title, r.calculateAbsolutePath(specPath), config.DocsAssetsURL}
+	if page.Title == "" {
+		page.Title = "API"
+	}
+	if page.AssetsURL == "" {
+		page.AssetsURL = defaultDocsAssetsURL
+	}
+	page.AssetsURL = strings.TrimSuffix(page.AssetsURL, "/")
+	r.Get(config.DocsPath, func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		docsTemplate.Execute(rw, page)
+	}).Hidden()
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="{{.AssetsURL}}/swagger-ui.css">
+</head>
+<body>
+<div id="docs"></div>
+<script src="{{.AssetsURL}}/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: {{.SpecURL}}, dom_id: "#docs"});</script>
+</body>
+</html>
+`))
+
+// openAPIPath converts a route path such as /users/:id/*path to the OpenAPI
+// form /users/{id}/{path}, returning the parameter names.
+func openAPIPath(p string) (string, []string) {
+	segments := strings.Split(p, "/")
+	var names []string
+	for i, s := range segments {
+		seg, err := parseSegment(s)
+		if err != nil || !seg.param && !seg.catchAll {
+			continue
+		}
+		names = append(names, seg.name)
+		segments[i] = seg.prefix + "{" + seg.name + "}" + seg.suffix
+	}
+	return strings.Join(segments, "/"), names
+}
+
+// schemaGenerator builds JSON schemas for Go types, collecting named structs
+// as components.
+type schemaGenerator struct {
+	schemas J
+	names   map[reflect.Type]string
+	used    map[string]bool
+}
+
+func (g *schemaGenerator) operation(rt *Route) J {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.hidden || !openAPIMethods[rt.Method] {
+		return nil
+	}
+
+	op := J{}
+	if rt.summary != "" {
+		op["summary"] = rt.summary
+	}
+	if rt.description != "" {
+		op["description"] = rt.description
+	}
+	if rt.operationID != "" {
+		op["operationId"] = rt.operationID
+	}
+	if len(rt.tags) > 0 {
+		op["tags"] = rt.tags
+	}
+	if rt.deprecated {
+		op["deprecated"] = true
+	}
+
+	var req requestShape
+	if rt.request != nil && indirectType(rt.request).Kind() == reflect.Struct {
+		req = g.request(indirectType(rt.request))
+	}
+	_, names := openAPIPath(rt.Path)
+	params := make([]J, 0, len(names)+len(req.params))
+	for _, name := range names {
+		param, ok := req.pathParams[name]
+		if !ok {
+			param = J{"name": name, "in": "path", "schema": J{"type": "string"}}
+		}
+		param["required"] = true
+		params = append(params, param)
+	}
+	params = append(params, req.params...)
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if req.body != nil && rt.Method != "GET" && rt.Method != "HEAD" {
+		op["requestBody"] = J{"required": true, "content": J{req.contentType: J{"schema": req.body}}}
+	}
+
+	responses := J{}
+	for code, t := range rt.responses {
+		description := http.StatusText(code)
+		if description == "" {
+			description = "Response"
+		}
+		resp := J{"description": description}
+		if t != nil {
+			resp["content"] = J{"application/json": J{"schema": g.schema(t)}}
+		}
+		responses[strconv.Itoa(code)] = resp
+	}
+	if len(responses) == 0 {
+		responses["200"] = J{"description": "OK"}
+	}
+	op["responses"] = responses
+
+	if names := rt.securitySchemes(); names != nil {
+		security := make([]J, len(names))
+		for i, name := range names {
+			security[i] = J{name: []string{}}
+		}
+		op["security"] = security
+	}
+	return op
+}
+
+// requestShape is a binding struct split into parameters and a request body.
+type requestShape struct {
+	pathParams  map[string]J
+	params      []J
+	body        J
+	contentType string
+}
+
+var ignoredHeaderParams = map[string]bool{"Accept": true, "Content-Type": true, "Authorization": true}
+
+func (g *schemaGenerator) request(t reflect.Type) requestShape {
+	shape := requestShape{pathParams: make(map[string]J)}
+	var jsonFields, formFields []reflect.StructField
+	hasFiles := false
+	for _, sf := range structFields(t) {
+		if name := tagName(sf.Tag.Get("param")); name != "" && name != "-" {
+			shape.pathParams[name] = g.parameter(sf, name, "path")
+			continue
+		}
+		if name := tagName(sf.Tag.Get("query")); name != "" && name != "-" {
+			shape.params = append(shape.params, g.parameter(sf, name, "query"))
+			continue
+		}
+		if name := tagName(sf.Tag.Get("header")); name != "" && name != "-" {
+			if !ignoredHeaderParams[http.CanonicalHeaderKey(name)] {
+				shape.params = append(shape.params, g.parameter(sf, name, "header"))
+			}
+			continue
+		}
+		if name := tagName(sf.Tag.Get("form")); name != "" && name != "-" {
+			formFields = append(formFields, sf)
+			if sf.Type == uploadedFileType || sf.Type == uploadedFilesType {
+				hasFiles = true
+			}
+			continue
+		}
+		jsonFields = append(jsonFields, sf)
+	}
+
+	switch {
+	case len(formFields) > 0:
+		shape.body = g.object(formFields, "form")
+		shape.contentType = "application/x-www-form-urlencoded"
+		if hasFiles {
+			shape.contentType = "multipart/form-data"
+		}
+	case len(jsonFields) > 0:
+		if len(shape.pathParams) == 0 && len(shape.params) == 0 {
+			shape.body = g.schema(t)
+		} else {
+			shape.body = g.object(jsonFields, "json")
+		}
+		shape.contentType = "application/json"
+	}
+	return shape
+}
+
+func (g *schemaGenerator) parameter(sf reflect.StructField, name, in string) J {
+	schema := g.field(sf)
+	param := J{"name": name, "in": in, "schema": schema}
+	if description, ok := schema["description"]; ok {
+		param["description"] = description
+		delete(schema, "description")
+	}
+	if isRequired(sf) {
+		param["required"] = true
+	}
+	if indirectType(sf.Type).Kind() == reflect.Slice && in == "query" {
+		param["explode"] = true
+	}
+	return param
+}
+
+// structFields returns the fields of t, with those of embedded structs
+// flattened into it.
+func structFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && indirectType(sf.Type).Kind() == reflect.Struct && tagName(sf.Tag.Get("json")) == "" {
+			fields = append(fields, structFields(indirectType(sf.Type))...)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		fields = append(fields, sf)
+	}
+	return fields
+}
+
+func isRequired(sf reflect.StructField) bool {
+	for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// object returns an object schema with a property for each field, named by
+// the given struct tag.
+func (g *schemaGenerator) object(fields []reflect.StructField, tag string) J {
+	properties := J{}
+	var required []string
+	for _, sf := range fields {
+		name := tagName(sf.Tag.Get(tag))
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		properties[name] = g.field(sf)
+		if isRequired(sf) {
+			required = append(required, name)
+		}
+	}
+	schema := J{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// field returns the schema of a struct field, including the constraints in
+// its validate tag and its description tag.
+func (g *schemaGenerator) field(sf reflect.StructField) J {
+	schema := g.schema(sf.Type)
+	t := indirectType(sf.Type)
+	for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			switch t.Kind() {
+			case reflect.String:
+				schema[name+"Length"] = n
+			case reflect.Slice, reflect.Array:
+				schema[name+"Items"] = n
+			case reflect.Map:
+				schema[name+"Properties"] = n
+			default:
+				schema[map[string]string{"min": "minimum", "max": "maximum"}[name]] = n
+			}
+		case "oneof":
+			var enum []interface{}
+			for _, o := range strings.Fields(arg) {
+				enum = append(enum, enumValue(t, o))
+			}
+			schema["enum"] = enum
+		}
+	}
+	if description := sf.Tag.Get("description"); description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
+
+func enumValue(t reflect.Type, s string) interface{} {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return s
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schema returns the JSON schema of values of type t as encoding/json would
+// encode them. Named structs are added to the components and referenced.
+func (g *schemaGenerator) schema(t reflect.Type) J {
+	if t == uploadedFileType {
+		return J{"type": "string", "format": "binary"}
+	}
+	t = indirectType(t)
+	switch {
+	case t == timeType:
+		return J{"type": "string", "format": "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return J{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return J{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return J{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return J{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return J{"type": "integer", "format": "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return J{"type": "integer", "minimum": 0}
+	case reflect.Float32:
+		return J{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return J{"type": "number", "format": "double"}
+	case reflect.String:
+		return J{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return J{"type": "string", "contentEncoding": "base64"}
+		}
+		return J{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return J{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(structFields(t), "json")
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.name(t)
+			g.names[t] = name
+			g.schemas[name] = J{}
+			g.schemas[name] = g.object(structFields(t), "json")
+		}
+		return J{"$ref": "#/components/schemas/" + name}
+	}
+	return J{}
+}
+
+var invalidSchemaName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// name returns a unique component name for t, qualified by its package if
+// another type has the same name.
+func (g *schemaGenerator) name(t reflect.Type) string {
+	name := strings.Trim(invalidSchemaName.ReplaceAllString(t.Name(), "_"), "_")
+	if g.used[name] {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	for i := 2; g.used[name]; i++ {
+		name = strings.TrimSuffix(name, "_"+strconv.Itoa(i-1)) + "_" + strconv.Itoa(i)
+	}
+	g.used[name] = true
+	return name
+}
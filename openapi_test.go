@@ -0,0 +1,125 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type Address struct {
+	Street string `json:"street" validate:"required"`
+	City   string `json:"city"`
+}
+
+type User struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name" validate:"required,min=1,max=100" description:"The user's full name"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role" validate:"oneof=admin member"`
+	Tags      []string  `json:"tags" validate:"max=10"`
+	Address   *Address  `json:"address,omitempty"`
+	Manager   *User     `json:"manager,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	password  string
+}
+
+type ListUsersRequest struct {
+	Limit  int      `query:"limit" validate:"min=1,max=100"`
+	Sort   []string `query:"sort"`
+	Tenant string   `header:"X-Tenant" validate:"required"`
+}
+
+type UpdateUserRequest struct {
+	ID    int    `param:"id"`
+	Name  string `json:"name" validate:"required"`
+	Email string `json:"email"`
+}
+
+type AvatarRequest struct {
+	Caption string               `form:"caption"`
+	Avatar  *engine.UploadedFile `form:"avatar" validate:"required"`
+}
+
+func nop(rw http.ResponseWriter, req *http.Request) {}
+
+func TestOpenAPI(t *testing.T) {
+	r := engine.NewRouter()
+	r.ServeOpenAPI(&engine.OpenAPIConfig{Title: "Users", Version: "1.0.0", Servers: []string{"https://api.example.com"}, DocsPath: "/docs"})
+	r.Get("/health", nop).Security()
+
+	api := r.SubRouter("/v1")
+	api.UseSecurity("bearer", &engine.SecurityScheme{Type: "http", Scheme: "bearer"}, func(next http.Handler) http.Handler {
+		return next
+	})
+	api.Get("/users", nop).
+		Summary("List users").
+		Tags("users").
+		Request(ListUsersRequest{}).
+		Response(http.StatusOK, []User{})
+	api.Post("/users", nop).
+		Request(User{}).
+		Response(http.StatusCreated, User{}).
+		Response(http.StatusUnprocessableEntity, engine.ValidationErrors{})
+	api.Patch("/users/:id", nop).
+		OperationID("updateUser").
+		Request(UpdateUserRequest{}).
+		Response(http.StatusOK, &User{})
+	api.Put("/users/:id/avatar", nop).Request(AvatarRequest{}).Response(http.StatusNoContent, nil)
+	api.Get("/files/*path", nop).Deprecated()
+	api.Delete("/users/:id", nop).Hidden()
+	api.Static("/static", ".")
+
+	c := enginetest.New(t, r)
+	c.Get("/openapi.json").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/json; charset=utf-8").
+		AssertJSONPath("openapi", "3.1.0").
+		AssertJSONPath("paths./v1/users.get.security.0.bearer", []string{}).
+		AssertJSONPath("paths./health.get.security", []string{}).
+		AssertGolden("openapi")
+	c.Get("/docs").Do().
+		AssertStatus(http.StatusOK).
+		AssertBodyContains(`url: "/openapi.json"`).
+		AssertBodyContains(`src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"`)
+}
+
+func TestOpenAPIDocsAssets(t *testing.T) {
+	r := engine.NewRouter()
+	api := r.SubRouter("/api")
+	api.ServeOpenAPI(&engine.OpenAPIConfig{DocsPath: "/docs", DocsAssetsURL: "/static/swagger-ui/"})
+	enginetest.New(t, r).Get("/api/docs").Do().
+		AssertStatus(http.StatusOK).
+		AssertBodyContains(`url: "/api/openapi.json"`).
+		AssertBodyContains(`href="/static/swagger-ui/swagger-ui.css"`).
+		AssertBodyContains(`src="/static/swagger-ui/swagger-ui-bundle.js"`)
+}
+
+func TestOpenAPISecurityAddedAfterRoutes(t *testing.T) {
+	r := engine.NewRouter()
+	api := r.SubRouter("/v1")
+	api.Get("/users", nop)
+	api.Get("/status", nop).Security()
+	r.Get("/health", nop)
+	api.UseSecurity("bearer", &engine.SecurityScheme{Type: "http", Scheme: "bearer"}, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") == "" {
+				engine.RenderError(rw, req, nil, http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	})
+	r.ServeOpenAPI(&engine.OpenAPIConfig{Title: "Users", Version: "1.0.0"})
+
+	c := enginetest.New(t, r)
+	c.Get("/v1/users").Do().AssertStatus(http.StatusUnauthorized)
+	c.Get("/openapi.json").Do().
+		AssertJSONPath("paths./v1/users.get.security.0.bearer", []string{}).
+		AssertJSONPath("paths./v1/status.get.security", []string{})
+	health := r.OpenAPI(nil)["paths"].(engine.J)["/health"].(engine.J)["get"].(engine.J)
+	if security, ok := health["security"]; ok {
+		t.Errorf("/health security = %v, want none", security)
+	}
+}
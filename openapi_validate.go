@@ -0,0 +1,821 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// OpenAPIValidatorConfig configures an OpenAPIValidator.
+type OpenAPIValidatorConfig struct {
+	// BasePath is the path the spec's paths are relative to. Defaults to the
+	// path of the spec's first server URL.
+	BasePath string
+	// MaxBodySize is the largest request body that will be read to validate
+	// it. Defaults to 10MB.
+	MaxBodySize int64
+	// ValidateResponses checks responses against the spec too, logging any
+	// mismatches with the request's logger. Responses are buffered to do so,
+	// so it's meant for development.
+	ValidateResponses bool
+}
+
+// OpenAPIValidator validates requests, and optionally responses, against an
+// OpenAPI 3 document.
+type OpenAPIValidator struct {
+	config  OpenAPIValidatorConfig
+	doc     map[string]interface{}
+	paths   []*specPath
+	mu      sync.Mutex
+	regexps map[string]*regexp.Regexp
+}
+
+type specPath struct {
+	template   string
+	names      []string // the parameter name of each segment, or "" for literals
+	segments   []string
+	literals   int
+	operations map[string]*specOperation
+}
+
+type specOperation struct {
+	params    []specParam
+	body      map[string]interface{}
+	responses map[string]interface{}
+}
+
+type specParam struct {
+	name     string
+	in       string
+	required bool
+	explode  bool
+	schema   interface{}
+}
+
+// NewOpenAPIValidator loads an OpenAPI 3.0 or 3.1 document, in JSON, from
+// spec. Add its Middleware to a router to validate requests before they reach
+// their handlers, and call CheckRoutes once the routes are registered.
+func NewOpenAPIValidator(spec io.Reader, config *OpenAPIValidatorConfig) (*OpenAPIValidator, error) {
+	if config == nil {
+		config = &OpenAPIValidatorConfig{}
+	}
+	v := &OpenAPIValidator{config: *config, regexps: make(map[string]*regexp.Regexp)}
+	if err := json.NewDecoder(spec).Decode(&v.doc); err != nil {
+		return nil, fmt.Errorf("engine: decoding OpenAPI document: %v", err)
+	}
+	if version, _ := v.doc["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, errors.New("engine: only OpenAPI 3 documents are supported")
+	}
+	if v.config.MaxBodySize == 0 {
+		v.config.MaxBodySize = 10 << 20
+	}
+	if v.config.BasePath == "" {
+		if servers, _ := v.doc["servers"].([]interface{}); len(servers) > 0 {
+			server, _ := servers[0].(map[string]interface{})
+			raw, _ := server["url"].(string)
+			if u, err := url.Parse(raw); err == nil && !strings.Contains(raw, "{") {
+				v.config.BasePath = u.Path
+			}
+		}
+	}
+	v.config.BasePath = strings.TrimSuffix(v.config.BasePath, "/")
+
+	paths, _ := v.doc["paths"].(map[string]interface{})
+	for template, item := range paths {
+		v.paths = append(v.paths, v.loadPath(template, v.resolve(item)))
+	}
+	sort.Slice(v.paths, func(i, j int) bool {
+		if v.paths[i].literals != v.paths[j].literals {
+			return v.paths[i].literals > v.paths[j].literals
+		}
+		return v.paths[i].template < v.paths[j].template
+	})
+	return v, nil
+}
+
+func (v *OpenAPIValidator) loadPath(template string, item map[string]interface{}) *specPath {
+	p := &specPath{template: template, segments: strings.Split(template, "/"), operations: make(map[string]*specOperation)}
+	p.names = make([]string, len(p.segments))
+	for i, s := range p.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			p.names[i] = s[1 : len(s)-1]
+		} else {
+			p.literals++
+		}
+	}
+	shared, _ := item["parameters"].([]interface{})
+	for method := range openAPIMethods {
+		raw, ok := item[strings.ToLower(method)]
+		if !ok {
+			continue
+		}
+		op := v.resolve(raw)
+		own, _ := op["parameters"].([]interface{})
+		p.operations[method] = &specOperation{
+			params:    v.loadParams(shared, own),
+			body:      v.resolve(op["requestBody"]),
+			responses: v.resolve(op["responses"]),
+		}
+	}
+	return p
+}
+
+// loadParams merges path item and operation parameters, the latter taking
+// precedence.
+func (v *OpenAPIValidator) loadParams(lists ...[]interface{}) []specParam {
+	var params []specParam
+	index := make(map[string]int)
+	for _, list := range lists {
+		for _, raw := range list {
+			m := v.resolve(raw)
+			p := specParam{schema: m["schema"]}
+			p.name, _ = m["name"].(string)
+			p.in, _ = m["in"].(string)
+			p.required, _ = m["required"].(bool)
+			p.explode = p.in == "query" || p.in == "cookie"
+			if explode, ok := m["explode"].(bool); ok {
+				p.explode = explode
+			}
+			if p.in == "path" {
+				p.required = true
+			}
+			if p.in == "header" && ignoredHeaderParams[http.CanonicalHeaderKey(p.name)] {
+				continue
+			}
+			key := p.in + " " + p.name
+			if i, ok := index[key]; ok {
+				params[i] = p
+				continue
+			}
+			index[key] = len(params)
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// resolve follows local $refs, returning nil for anything that isn't an
+// object.
+func (v *OpenAPIValidator) resolve(node interface{}) map[string]interface{} {
+	for i := 0; i < 32; i++ {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#/") {
+			return m
+		}
+		node = v.doc
+		for _, token := range strings.Split(ref[2:], "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			parent, _ := node.(map[string]interface{})
+			node = parent[token]
+		}
+	}
+	return nil
+}
+
+func (v *OpenAPIValidator) match(method, urlPath string) (*specOperation, map[string]string) {
+	if !strings.HasPrefix(urlPath, v.config.BasePath) {
+		return nil, nil
+	}
+	segments := strings.Split(strings.TrimPrefix(urlPath, v.config.BasePath), "/")
+	for _, p := range v.paths {
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, s := range segments {
+			if p.names[i] != "" {
+				params[p.names[i]] = s
+			} else if p.segments[i] != s {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		op, ok := p.operations[method]
+		if !ok && method == "HEAD" {
+			op, ok = p.operations["GET"]
+		}
+		if ok {
+			return op, params
+		}
+	}
+	return nil, nil
+}
+
+// Middleware validates requests for the spec's operations before calling the
+// handler. Invalid parameters and malformed bodies are rejected with a 400,
+// and bodies that don't match their schema with a 422, both as
+// ValidationErrors. Requests the spec doesn't describe are passed through.
+func (v *OpenAPIValidator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		op, pathParams := v.match(req.Method, req.URL.Path)
+		if op == nil {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if code, err := v.validateRequest(op, pathParams, req); err != nil {
+			JSONError(rw, err, code)
+			return
+		}
+		if !v.config.ValidateResponses {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		buf := newResponseBuffer(rw, 0)
+		next.ServeHTTP(buf, req)
+		if !buf.passthrough {
+			if errs := v.validateResponse(op, buf); len(errs) > 0 {
+				logger := log.WithFields(log.Fields{})
+				if md, ok := GetMetadata(GetContext(req)); ok {
+					logger = md.Logger()
+				}
+				logger.WithFields(log.Fields{"status": buf.Status(), "errors": errs.Error()}).Warn("Response doesn't match the OpenAPI spec")
+			}
+		}
+		buf.flush()
+	})
+}
+
+func (v *OpenAPIValidator) validateRequest(op *specOperation, pathParams map[string]string, req *http.Request) (int, error) {
+	var errs ValidationErrors
+	query := req.URL.Query()
+	for _, p := range op.params {
+		var raw []string
+		switch p.in {
+		case "path":
+			if s, ok := pathParams[p.name]; ok {
+				raw = []string{s}
+			}
+		case "query":
+			raw = query[p.name]
+		case "header":
+			raw = req.Header[http.CanonicalHeaderKey(p.name)]
+		case "cookie":
+			if c, err := req.Cookie(p.name); err == nil {
+				raw = []string{c.Value}
+			}
+		}
+		if len(raw) == 0 {
+			if p.required {
+				errs = append(errs, FieldError{Field: p.name, Source: p.in, Message: "is required"})
+			}
+			continue
+		}
+		sv := &schemaValidator{v: v, source: p.in, request: true}
+		value, msg := sv.coerce(raw, v.resolve(p.schema), p.explode)
+		if msg != "" {
+			errs = append(errs, FieldError{Field: p.name, Source: p.in, Message: msg})
+			continue
+		}
+		errs = append(errs, sv.validate(p.schema, value, p.name)...)
+	}
+
+	body, err := v.readBody(op, req)
+	if err == ErrRequestTooLarge {
+		return http.StatusRequestEntityTooLarge, err
+	}
+	if verrs, ok := err.(ValidationErrors); ok {
+		errs = append(errs, verrs...)
+	} else if err != nil {
+		return http.StatusUnsupportedMediaType, err
+	}
+	if len(errs) > 0 {
+		return http.StatusBadRequest, errs
+	}
+	if body != nil {
+		sv := &schemaValidator{v: v, source: "body", request: true}
+		if errs := sv.validate(body.schema, body.value, ""); len(errs) > 0 {
+			return http.StatusUnprocessableEntity, errs
+		}
+	}
+	return 0, nil
+}
+
+type decodedBody struct {
+	schema interface{}
+	value  interface{}
+}
+
+// readBody checks the request body's presence and content type and decodes
+// JSON bodies, leaving the body in place for the handler.
+func (v *OpenAPIValidator) readBody(op *specOperation, req *http.Request) (*decodedBody, error) {
+	if op.body == nil {
+		return nil, nil
+	}
+	ctx := GetContext(req)
+	var data []byte
+	if ctx.ReadCloser != nil && ctx.ReadCloser != http.NoBody {
+		var err error
+		data, err = io.ReadAll(io.LimitReader(ctx.ReadCloser, v.config.MaxBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) > v.config.MaxBodySize {
+			return nil, ErrRequestTooLarge
+		}
+		ctx.ReadCloser = io.NopCloser(bytes.NewReader(data))
+	}
+	if len(data) == 0 {
+		if required, _ := op.body["required"].(bool); required {
+			return nil, ValidationErrors{{Source: "body", Message: "Request body is required"}}
+		}
+		return nil, nil
+	}
+
+	content, _ := op.body["content"].(map[string]interface{})
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	media, ok := content[mediaType]
+	if !ok {
+		media, ok = content[strings.SplitN(mediaType, "/", 2)[0]+"/*"]
+	}
+	if !ok {
+		media, ok = content["*/*"]
+	}
+	if !ok {
+		types := make([]string, 0, len(content))
+		for t := range content {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return nil, fmt.Errorf("Content-Type must be one of %s", strings.Join(types, ", "))
+	}
+	schema := v.resolve(media)["schema"]
+	if schema == nil || !isJSONMediaType(mediaType) {
+		return nil, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, ValidationErrors{{Source: "body", Message: "Invalid JSON: " + err.Error()}}
+	}
+	return &decodedBody{schema: schema, value: value}, nil
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func (v *OpenAPIValidator) validateResponse(op *specOperation, buf *responseBuffer) ValidationErrors {
+	status := strconv.Itoa(buf.Status())
+	raw, ok := op.responses[status]
+	if !ok {
+		raw, ok = op.responses[status[:1]+"XX"]
+	}
+	if !ok {
+		raw, ok = op.responses["default"]
+	}
+	if !ok {
+		return ValidationErrors{{Source: "response", Message: "status " + status + " isn't documented"}}
+	}
+	if buf.body.Len() == 0 {
+		return nil
+	}
+	content, _ := v.resolve(raw)["content"].(map[string]interface{})
+	if len(content) == 0 {
+		return ValidationErrors{{Source: "response", Message: "has a body but none is documented"}}
+	}
+	mediaType, _, _ := mime.ParseMediaType(buf.header.Get("Content-Type"))
+	media, ok := content[mediaType]
+	if !ok {
+		return ValidationErrors{{Source: "response", Message: "Content-Type " + mediaType + " isn't documented"}}
+	}
+	schema := v.resolve(media)["schema"]
+	if schema == nil || !isJSONMediaType(mediaType) {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(buf.body.Bytes(), &value); err != nil {
+		return ValidationErrors{{Source: "response", Message: "Invalid JSON: " + err.Error()}}
+	}
+	sv := &schemaValidator{v: v, source: "response"}
+	return sv.validate(schema, value, "")
+}
+
+// CheckRoutes logs and returns the spec's operations that have no route
+// registered on r, and r's routes that have no operation in the spec. Call it
+// once all the routes are registered. Hidden routes are ignored.
+func (v *OpenAPIValidator) CheckRoutes(r *Router) []string {
+	type route struct{ method, path, key string }
+	var routes []route
+	registered := make(map[string]bool)
+	for _, rt := range r.Routes() {
+		rt.mu.Lock()
+		hidden := rt.hidden
+		rt.mu.Unlock()
+		documented, ok := rt.documentedPath()
+		if hidden || !ok {
+			continue
+		}
+		p, _ := openAPIPath(documented)
+		key := rt.Method + " " + normalizeSpecPath(p)
+		registered[key] = true
+		routes = append(routes, route{rt.Method, p, key})
+	}
+
+	var problems []string
+	documented := make(map[string]bool)
+	for _, p := range v.paths {
+		full := v.config.BasePath + p.template
+		for method := range p.operations {
+			key := method + " " + normalizeSpecPath(full)
+			documented[key] = true
+			if !registered[key] {
+				problems = append(problems, fmt.Sprintf("%s %s is in the OpenAPI spec but has no route", method, full))
+			}
+		}
+	}
+	for _, rt := range routes {
+		if documented[rt.key] || rt.method == "HEAD" && documented["GET "+normalizeSpecPath(rt.path)] {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("%s %s has no operation in the OpenAPI spec", rt.method, rt.path))
+	}
+	sort.Strings(problems)
+	for _, problem := range problems {
+		log.Warn(problem)
+	}
+	return problems
+}
+
+var specParamSegment = regexp.MustCompile(`\{[^/]*\}`)
+
+// normalizeSpecPath replaces parameter names so paths can be compared by shape.
+func normalizeSpecPath(p string) string {
+	return specParamSegment.ReplaceAllString(p, "{}")
+}
+
+// schemaValidator validates values against JSON schemas.
+type schemaValidator struct {
+	v      *OpenAPIValidator
+	source string
+	// request is set when validating requests, so readOnly properties aren't
+	// required. Otherwise writeOnly properties aren't.
+	request bool
+}
+
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// coerce converts a parameter's raw string values to the type its schema
+// expects.
+func (sv *schemaValidator) coerce(raw []string, schema map[string]interface{}, explode bool) (interface{}, string) {
+	types := schemaTypes(schema)
+	if types["array"] {
+		if !explode {
+			raw = strings.Split(raw[0], ",")
+		}
+		items := sv.v.resolve(schema["items"])
+		values := make([]interface{}, len(raw))
+		for i, s := range raw {
+			value, msg := sv.coerceScalar(s, schemaTypes(items))
+			if msg != "" {
+				return nil, msg
+			}
+			values[i] = value
+		}
+		return values, ""
+	}
+	return sv.coerceScalar(raw[0], types)
+}
+
+func (sv *schemaValidator) coerceScalar(s string, types map[string]bool) (interface{}, string) {
+	switch {
+	case types["string"] || len(types) == 0:
+		return s, ""
+	case types["integer"]:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, "must be an integer"
+		}
+		return float64(n), ""
+	case types["number"]:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, "must be a number"
+		}
+		return n, ""
+	case types["boolean"]:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, "must be true or false"
+		}
+		return b, ""
+	}
+	return s, ""
+}
+
+// schemaTypes returns the types a schema allows, from its type and, for
+// OpenAPI 3.0, nullable keywords.
+func schemaTypes(schema map[string]interface{}) map[string]bool {
+	types := make(map[string]bool)
+	switch t := schema["type"].(type) {
+	case string:
+		types[t] = true
+	case []interface{}:
+		for _, s := range t {
+			if s, ok := s.(string); ok {
+				types[s] = true
+			}
+		}
+	}
+	if nullable, _ := schema["nullable"].(bool); nullable && len(types) > 0 {
+		types["null"] = true
+	}
+	return types
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+var typeArticles = map[string]string{
+	"null": "null", "boolean": "a boolean", "integer": "an integer", "number": "a number",
+	"string": "a string", "array": "an array", "object": "an object",
+}
+
+func (sv *schemaValidator) validate(node interface{}, value interface{}, field string) (errs ValidationErrors) {
+	if b, ok := node.(bool); ok {
+		if !b {
+			errs = append(errs, FieldError{Field: field, Source: sv.source, Message: "is not allowed"})
+		}
+		return errs
+	}
+	schema := sv.v.resolve(node)
+	if schema == nil {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) ValidationErrors {
+		return append(errs, FieldError{Field: field, Source: sv.source, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 {
+		t := jsonTypeOf(value)
+		if !types[t] && !(t == "integer" && types["number"]) {
+			var names []string
+			for _, name := range []string{"boolean", "integer", "number", "string", "array", "object", "null"} {
+				if types[name] {
+					names = append(names, typeArticles[name])
+				}
+			}
+			return fail("must be %s", strings.Join(names, " or "))
+		}
+	}
+	if value == nil {
+		return nil
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		options := make([]string, len(enum))
+		for i, e := range enum {
+			options[i] = fmt.Sprint(e)
+			found = found || reflect.DeepEqual(e, value)
+		}
+		if !found {
+			return fail("must be one of %s", strings.Join(options, ", "))
+		}
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		return fail("must be %v", c)
+	}
+
+	switch value := value.(type) {
+	case string:
+		errs = append(errs, sv.validateString(schema, value, field)...)
+	case float64:
+		errs = append(errs, sv.validateNumber(schema, value, field)...)
+	case []interface{}:
+		if n, ok := schema["minItems"].(float64); ok && float64(len(value)) < n {
+			errs = fail("must be at least %v items long", n)
+		}
+		if n, ok := schema["maxItems"].(float64); ok && float64(len(value)) > n {
+			errs = fail("must be at most %v items long", n)
+		}
+		if unique, _ := schema["uniqueItems"].(bool); unique {
+			for i := range value {
+				for j := i + 1; j < len(value); j++ {
+					if reflect.DeepEqual(value[i], value[j]) {
+						errs = fail("must not contain duplicates")
+						i = len(value)
+						break
+					}
+				}
+			}
+		}
+		if items, ok := schema["items"]; ok {
+			for i, item := range value {
+				errs = append(errs, sv.validate(items, item, joinField(field, strconv.Itoa(i)))...)
+			}
+		}
+	case map[string]interface{}:
+		errs = append(errs, sv.validateObject(schema, value, field)...)
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range all {
+			errs = append(errs, sv.validate(s, value, field)...)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, s := range anyOf {
+			if len(sv.validate(s, value, field)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			errs = fail("must match at least one of the allowed schemas")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, s := range oneOf {
+			if len(sv.validate(s, value, field)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			errs = fail("must match exactly one of the allowed schemas")
+		}
+	}
+	if not, ok := schema["not"]; ok && len(sv.validate(not, value, field)) == 0 {
+		errs = fail("must not match the disallowed schema")
+	}
+	return errs
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func (sv *schemaValidator) validateString(schema map[string]interface{}, s, field string) (errs ValidationErrors) {
+	fail := func(msg string) {
+		errs = append(errs, FieldError{Field: field, Source: sv.source, Message: msg})
+	}
+	n := float64(utf8.RuneCountInString(s))
+	if min, ok := schema["minLength"].(float64); ok && n < min {
+		fail(fmt.Sprintf("must be at least %v characters long", min))
+	}
+	if max, ok := schema["maxLength"].(float64); ok && n > max {
+		fail(fmt.Sprintf("must be at most %v characters long", max))
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if re := sv.v.regexp(pattern); re != nil && !re.MatchString(s) {
+			fail("must match the pattern " + pattern)
+		}
+	}
+	format, _ := schema["format"].(string)
+	valid := true
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		valid = err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		valid = err == nil
+	case "email":
+		addr, err := mail.ParseAddress(s)
+		valid = err == nil && addr.Address == s
+	case "uuid":
+		valid = uuidPattern.MatchString(s)
+	case "uri":
+		u, err := url.Parse(s)
+		valid = err == nil && u.Scheme != ""
+	case "ipv4":
+		ip := net.ParseIP(s)
+		valid = ip != nil && ip.To4() != nil
+	case "ipv6":
+		ip := net.ParseIP(s)
+		valid = ip != nil && ip.To4() == nil
+	}
+	if !valid {
+		fail("must be a valid " + format)
+	}
+	return errs
+}
+
+func (v *OpenAPIValidator) regexp(pattern string) *regexp.Regexp {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	re, ok := v.regexps[pattern]
+	if !ok {
+		// Patterns Go can't compile are skipped rather than failing every request.
+		re, _ = regexp.Compile(pattern)
+		v.regexps[pattern] = re
+	}
+	return re
+}
+
+func (sv *schemaValidator) validateNumber(schema map[string]interface{}, n float64, field string) (errs ValidationErrors) {
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Source: sv.source, Message: fmt.Sprintf(format, args...)})
+	}
+	if min, ok := schema["minimum"].(float64); ok {
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive && n <= min {
+			fail("must be greater than %v", min)
+		} else if n < min {
+			fail("must be at least %v", min)
+		}
+	}
+	if max, ok := schema["maximum"].(float64); ok {
+		if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive && n >= max {
+			fail("must be less than %v", max)
+		} else if n > max {
+			fail("must be at most %v", max)
+		}
+	}
+	if min, ok := schema["exclusiveMinimum"].(float64); ok && n <= min {
+		fail("must be greater than %v", min)
+	}
+	if max, ok := schema["exclusiveMaximum"].(float64); ok && n >= max {
+		fail("must be less than %v", max)
+	}
+	if m, ok := schema["multipleOf"].(float64); ok && m > 0 {
+		if q := n / m; q != math.Trunc(q) {
+			fail("must be a multiple of %v", m)
+		}
+	}
+	return errs
+}
+
+func (sv *schemaValidator) validateObject(schema map[string]interface{}, obj map[string]interface{}, field string) (errs ValidationErrors) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := obj[name]; ok {
+				continue
+			}
+			prop := sv.v.resolve(properties[name])
+			readOnly, _ := prop["readOnly"].(bool)
+			writeOnly, _ := prop["writeOnly"].(bool)
+			if sv.request && readOnly || !sv.request && writeOnly {
+				continue
+			}
+			errs = append(errs, FieldError{Field: joinField(field, name), Source: sv.source, Message: "is required"})
+		}
+	}
+	if n, ok := schema["minProperties"].(float64); ok && float64(len(obj)) < n {
+		errs = append(errs, FieldError{Field: field, Source: sv.source, Message: fmt.Sprintf("must have at least %v properties", n)})
+	}
+	if n, ok := schema["maxProperties"].(float64); ok && float64(len(obj)) > n {
+		errs = append(errs, FieldError{Field: field, Source: sv.source, Message: fmt.Sprintf("must have at most %v properties", n)})
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	additional, hasAdditional := schema["additionalProperties"]
+	for _, name := range names {
+		if prop, ok := properties[name]; ok {
+			errs = append(errs, sv.validate(prop, obj[name], joinField(field, name))...)
+		} else if hasAdditional {
+			errs = append(errs, sv.validate(additional, obj[name], joinField(field, name))...)
+		}
+	}
+	return errs
+}
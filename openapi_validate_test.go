@@ -0,0 +1,196 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const petSpec = `{
+  "openapi": "3.1.0",
+  "info": {"title": "Pets", "version": "1.0.0"},
+  "servers": [{"url": "https://api.example.com/v1"}],
+  "paths": {
+    "/pets": {
+      "get": {
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100}},
+          {"name": "tags", "in": "query", "schema": {"type": "array", "items": {"type": "string"}}},
+          {"name": "X-Tenant", "in": "header", "required": true, "schema": {"type": "string", "format": "uuid"}}
+        ],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Pet"}}}}}
+        }
+      },
+      "post": {
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}
+        },
+        "responses": {
+          "201": {"description": "Created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}}
+        }
+      }
+    },
+    "/pets/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+      "get": {"responses": {"200": {"description": "OK"}}},
+      "delete": {"responses": {"204": {"description": "No Content"}}}
+    }
+  },
+  "components": {
+    "schemas": {
+      "Pet": {
+        "type": "object",
+        "required": ["id", "name"],
+        "additionalProperties": false,
+        "properties": {
+          "id": {"type": "integer", "readOnly": true},
+          "name": {"type": "string", "minLength": 1},
+          "kind": {"enum": ["cat", "dog"]},
+          "owner": {"type": ["string", "null"], "format": "email"}
+        }
+      }
+    }
+  }
+}`
+
+func newPetRouter(t *testing.T, config *engine.OpenAPIValidatorConfig) (*engine.Router, *engine.OpenAPIValidator) {
+	v, err := engine.NewOpenAPIValidator(strings.NewReader(petSpec), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := engine.NewRouter()
+	r.Use(v.Middleware)
+	api := r.SubRouter("/v1")
+	api.Get("/pets", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, []engine.J{{"id": 1, "name": "Rex", "kind": "dog"}}, http.StatusOK)
+	})
+	api.Post("/pets", func(rw http.ResponseWriter, req *http.Request) {
+		pet, _ := engine.ParseJSON(req)
+		pet["id"] = 2
+		engine.JSON(rw, pet, http.StatusCreated)
+	})
+	api.Get("/pets/:id", nop)
+	api.Put("/pets/:id", nop)
+	return r, v
+}
+
+func TestOpenAPIValidatorRequests(t *testing.T) {
+	r, _ := newPetRouter(t, nil)
+	c := enginetest.New(t, r).Header("X-Tenant", "6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	c.Get("/v1/pets").Query("limit", "10").Query("tags", "a").Query("tags", "b").Do().AssertStatus(http.StatusOK)
+	c.Get("/v1/pets").Query("limit", "0").Do().
+		AssertStatus(http.StatusBadRequest).
+		AssertJSONPath("errors.0", engine.FieldError{Field: "limit", Source: "query", Message: "must be at least 1"})
+	c.Get("/v1/pets").Query("limit", "ten").Do().
+		AssertStatus(http.StatusBadRequest).
+		AssertJSONPath("errors.0.message", "must be an integer")
+	enginetest.New(t, r).Get("/v1/pets").Do().
+		AssertStatus(http.StatusBadRequest).
+		AssertJSONPath("errors.0", engine.FieldError{Field: "X-Tenant", Source: "header", Message: "is required"})
+	c.Get("/v1/pets/abc").Do().
+		AssertStatus(http.StatusBadRequest).
+		AssertJSONPath("errors.0", engine.FieldError{Field: "id", Source: "path", Message: "must be an integer"})
+	c.Get("/v1/pets/1").Do().AssertStatus(http.StatusOK)
+
+	c.Post("/v1/pets").JSON(engine.J{"name": "Tom", "kind": "cat", "owner": nil}).Do().
+		AssertStatus(http.StatusCreated).
+		AssertJSONPath("name", "Tom")
+	c.Post("/v1/pets").JSON(engine.J{"name": "", "kind": "fish", "owner": "nobody", "age": 3}).Do().
+		AssertStatus(http.StatusUnprocessableEntity).
+		AssertJSONPath("errors", []engine.FieldError{
+			{Field: "age", Source: "body", Message: "is not allowed"},
+			{Field: "kind", Source: "body", Message: "must be one of cat, dog"},
+			{Field: "name", Source: "body", Message: "must be at least 1 characters long"},
+			{Field: "owner", Source: "body", Message: "must be a valid email"},
+		})
+	c.Post("/v1/pets").Body("application/json", strings.NewReader("{")).Do().
+		AssertStatus(http.StatusBadRequest)
+	c.Post("/v1/pets").Do().
+		AssertStatus(http.StatusBadRequest).
+		AssertJSONPath("errors.0.message", "Request body is required")
+	c.Post("/v1/pets").Body("text/plain", strings.NewReader("Tom")).Do().
+		AssertStatus(http.StatusUnsupportedMediaType)
+}
+
+func TestOpenAPIValidatorResponses(t *testing.T) {
+	logs := enginetest.CaptureLogs(t)
+	r, _ := newPetRouter(t, &engine.OpenAPIValidatorConfig{ValidateResponses: true})
+	c := enginetest.New(t, r).Header("X-Tenant", "6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	c.Get("/v1/pets").Do().AssertStatus(http.StatusOK)
+	c.Post("/v1/pets").JSON(engine.J{"name": "Tom"}).Do().AssertStatus(http.StatusCreated).AssertJSONPath("id", 2)
+	c.Get("/v1/pets/1").Do().AssertStatus(http.StatusOK)
+	if logs.Contains("OpenAPI") {
+		t.Errorf("valid responses logged as mismatches: %+v", logs.Entries())
+	}
+
+	v, _ := engine.NewOpenAPIValidator(strings.NewReader(petSpec), &engine.OpenAPIValidatorConfig{ValidateResponses: true})
+	bad := engine.NewRouter()
+	bad.Use(v.Middleware)
+	bad.Get("/v1/pets", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, []engine.J{{"name": 5}}, http.StatusOK)
+	})
+	enginetest.New(t, bad).Get("/v1/pets").Header("X-Tenant", "6ba7b810-9dad-11d1-80b4-00c04fd430c8").Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("0.name", 5)
+	var entry *enginetest.LogEntry
+	for _, e := range logs.Entries() {
+		if e.Message == "Response doesn't match the OpenAPI spec" {
+			entry = &e
+		}
+	}
+	if entry == nil {
+		t.Fatalf("mismatch wasn't logged: %+v", logs.Entries())
+	}
+	if entry.Fields["errors"] != "0.id is required, 0.name must be a string" || entry.Fields["request_id"] == nil {
+		t.Errorf("logged %+v", entry.Fields)
+	}
+}
+
+func TestOpenAPIValidatorCheckRoutes(t *testing.T) {
+	enginetest.CaptureLogs(t)
+	r, v := newPetRouter(t, nil)
+	want := []string{
+		"DELETE /v1/pets/{id} is in the OpenAPI spec but has no route",
+		"PUT /v1/pets/{id} has no operation in the OpenAPI spec",
+	}
+	if got := v.CheckRoutes(r); !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckRoutes() = %q, want %q", got, want)
+	}
+}
+
+func TestOpenAPIValidatorGeneratedSpec(t *testing.T) {
+	r := engine.NewRouter()
+	r.ServeOpenAPI(nil)
+	r.Post("/users", nop).Request(User{}).Response(http.StatusCreated, User{})
+	r.Get("/users", nop).Request(ListUsersRequest{})
+	res := enginetest.New(t, r).Get("/openapi.json").Do().AssertStatus(http.StatusOK)
+
+	v, err := engine.NewOpenAPIValidator(res.Body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vr := engine.NewRouter()
+	vr.Use(v.Middleware)
+	vr.Post("/users", nop)
+	vr.Get("/users", nop)
+	c := enginetest.New(t, vr)
+	c.Post("/users").JSON(engine.J{"name": "Matthew", "role": "admin", "tags": []string{}}).Do().AssertStatus(http.StatusOK)
+	c.Post("/users").JSON(engine.J{"role": "owner"}).Do().
+		AssertStatus(http.StatusUnprocessableEntity).
+		AssertJSONPath("errors.0.field", "name").
+		AssertJSONPath("errors.1.field", "role")
+	c.Get("/users").Header("X-Tenant", "acme").Query("limit", "5").Do().AssertStatus(http.StatusOK)
+	c.Get("/users").Header("X-Tenant", "acme").Query("limit", "500").Do().
+		AssertStatus(http.StatusBadRequest).
+		AssertJSONPath("errors.0.message", "must be at most 100")
+	if problems := v.CheckRoutes(vr); len(problems) != 0 {
+		t.Errorf("CheckRoutes() = %q", problems)
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// Option configures a Router made by NewRouter.
+type Option func(*routerOptions)
+
+// ErrorRenderer writes an error response, like JSONError.
+type ErrorRenderer func(rw http.ResponseWriter, req *http.Request, err error, code int)
+
+type routerOptions struct {
+	middleware             []MiddlewareFunc
+	redirectTrailingSlash  bool
+	redirectFixedPath      bool
+	handleMethodNotAllowed bool
+	cleanPath              bool
+	caseInsensitive        bool
+	strict                 bool
+	panicHandler           func(http.ResponseWriter, *http.Request, interface{})
+	logger                 *log.Logger
+	renderError            ErrorRenderer
+	requestID              func() string
+}
+
+// defaultOptions returns NewRouter's options when it's given none.
+func defaultOptions() routerOptions {
+	return routerOptions{
+		middleware:             []MiddlewareFunc{MetadataMiddleware},
+		redirectTrailingSlash:  true,
+		redirectFixedPath:      true,
+		handleMethodNotAllowed: true,
+	}
+}
+
+// WithMiddleware replaces the router's default middleware, MetadataMiddleware.
+// Call it with none for a router without middleware.
+func WithMiddleware(middleware ...MiddlewareFunc) Option {
+	return func(o *routerOptions) {
+		o.middleware = append([]MiddlewareFunc(nil), middleware...)
+	}
+}
+
+// RedirectTrailingSlash sets whether requests for /foo/ are redirected to
+// /foo when only /foo has a route, and the other way around. On by default.
+func RedirectTrailingSlash(enabled bool) Option {
+	return func(o *routerOptions) {
+		o.redirectTrailingSlash = enabled
+	}
+}
+
+// RedirectFixedPath sets whether requests are redirected to the cleaned,
+// case-corrected path of a route when their own path has none, e.g. /FOO/../bar
+// to /bar. On by default.
+func RedirectFixedPath(enabled bool) Option {
+	return func(o *routerOptions) {
+		o.redirectFixedPath = enabled
+	}
+}
+
+// HandleMethodNotAllowed sets whether requests whose path has routes for other
+// methods get a 405. Otherwise they get a 404. On by default.
+func HandleMethodNotAllowed(enabled bool) Option {
+	return func(o *routerOptions) {
+		o.handleMethodNotAllowed = enabled
+	}
+}
+
+// CleanPath routes requests by their cleaned path, removing . and .. elements
+// and repeated slashes, instead of redirecting to it.
+func CleanPath() Option {
+	return func(o *routerOptions) {
+		o.cleanPath = true
+	}
+}
+
+// CaseInsensitive serves requests whose path matches a route ignoring case,
+// instead of redirecting them. Path params keep the request's case. It turns
+// off RedirectFixedPath.
+func CaseInsensitive() Option {
+	return func(o *routerOptions) {
+		o.caseInsensitive = true
+		o.redirectFixedPath = false
+	}
+}
+
+// Strict makes registering a route that conflicts with another panic with a
+// message naming both routes and where they were registered. Routes differing
+// only in a trailing slash, or in case with CaseInsensitive, conflict too.
+func Strict() Option {
+	return func(o *routerOptions) {
+		o.strict = true
+	}
+}
+
+// WithPanicHandler handles panics that aren't recovered by middleware such as
+// MetadataMiddleware.
+func WithPanicHandler(handler func(rw http.ResponseWriter, req *http.Request, err interface{})) Option {
+	return func(o *routerOptions) {
+		o.panicHandler = handler
+	}
+}
+
+// WithLogger sets the logger used for requests' access logs and by
+// RequestMetadata.Logger. Defaults to logrus's standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(o *routerOptions) {
+		o.logger = logger
+	}
+}
+
+// WithErrorRenderer sets how the router renders its own errors, such as 404s,
+// 405s and recovered panics, and those passed to RenderError. Defaults to
+// JSONError.
+func WithErrorRenderer(render ErrorRenderer) Option {
+	return func(o *routerOptions) {
+		o.renderError = render
+	}
+}
+
+// WithRequestID sets how MetadataMiddleware generates request IDs. Defaults
+// to random UUIDs.
+func WithRequestID(generate func() string) Option {
+	return func(o *routerOptions) {
+		o.requestID = generate
+	}
+}
+
+// RenderError writes err with the error renderer of the router serving req.
+func RenderError(rw http.ResponseWriter, req *http.Request, err error, code int) {
+	if render := GetContext(req).options().renderError; render != nil {
+		if err == nil {
+			err = fmt.Errorf("%s", http.StatusText(code))
+		}
+		render(rw, req, err, code)
+		return
+	}
+	JSONError(rw, err, code)
+}
+
+// options returns the options of the router serving the request, which are
+// empty if it isn't served by a router.
+func (c *Context) options() *routerOptions {
+	if c.router == nil {
+		return &routerOptions{}
+	}
+	return c.router.routes.options
+}
+
+// register adds handle to the mux. In strict mode conflicts are reported with
+// the routes involved.
+func (r *Router) register(method, path string, handle httprouter.Handle) {
+	if !r.routes.options.strict {
+		r.mux.Handle(method, path, handle)
+		return
+	}
+	for _, rt := range r.Routes() {
+		if rt.Method == method && pathsConflict(rt.Path, path, r.routes.options.caseInsensitive) {
+			panic(fmt.Sprintf("engine: %s %s registered at %s conflicts with %s %s registered at %s", method, path, caller(), rt.Method, rt.Path, rt.source))
+		}
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			panic(fmt.Sprintf("engine: %s %s registered at %s: %v", method, path, caller(), err))
+		}
+	}()
+	r.mux.Handle(method, path, handle)
+}
+
+// pathsConflict reports whether routes for paths a and b would match the same
+// requests, or differ only by a trailing slash or, with fold, case.
+func pathsConflict(a, b string, fold bool) bool {
+	ka, kb := pathKey(a), pathKey(b)
+	return ka == kb || fold && strings.EqualFold(ka, kb)
+}
+
+// pathKey returns path with its params replaced by their keys, and without a
+// trailing slash.
+func pathKey(path string) string {
+	segments, err := parsePath(path)
+	if err != nil {
+		return path
+	}
+	keys := make([]string, len(segments))
+	for i, seg := range segments {
+		keys[i] = seg.key()
+	}
+	return strings.TrimSuffix(strings.Join(keys, "/"), "/")
+}
+
+// caller returns the file and line of the first caller outside engine, where
+// a route was registered.
+func caller() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/mnbbrown/engine.") || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
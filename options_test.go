@@ -0,0 +1,95 @@
+package engine_test
+
+import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNewRouterOptions(t *testing.T) {
+	r := engine.NewRouter(engine.WithMiddleware(), engine.RedirectTrailingSlash(false), engine.HandleMethodNotAllowed(false))
+	r.Get("/users", writeMethod)
+	if len(r.ListMiddleware()) != 0 {
+		t.Errorf("middleware = %v, want none", r.ListMiddleware())
+	}
+	c := enginetest.New(t, r)
+	c.Get("/users").Do().AssertStatus(http.StatusOK).AssertHeader("Request-Id", "")
+	c.Get("/users/").Do().AssertStatus(http.StatusNotFound)
+	c.Post("/users").Do().AssertStatus(http.StatusNotFound)
+}
+
+func TestNewRouterPaths(t *testing.T) {
+	r := engine.NewRouter(engine.CleanPath(), engine.CaseInsensitive())
+	r.Get("/Users/:name", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(engine.GetContext(req).Params.ByName("name")))
+	})
+	c := enginetest.New(t, r)
+	c.Get("/users/Matthew").Do().AssertStatus(http.StatusOK).AssertBody("Matthew")
+	c.Get("/x/..//USERS/Matthew").Do().AssertStatus(http.StatusOK).AssertBody("Matthew")
+	c.Head("/users/Matthew").Do().AssertStatus(http.StatusOK).AssertHeader("Content-Length", "7")
+
+	fixed := engine.NewRouter()
+	fixed.Get("/Users/:name", writeMethod)
+	enginetest.New(t, fixed).Get("/users/Matthew").Do().
+		AssertStatus(http.StatusMovedPermanently).
+		AssertHeader("Location", "/Users/Matthew")
+}
+
+func TestNewRouterInjection(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.New()
+	logger.Out = &out
+	r := engine.NewRouter(
+		engine.WithLogger(logger),
+		engine.WithRequestID(func() string { return "req-1" }),
+		engine.WithErrorRenderer(func(rw http.ResponseWriter, req *http.Request, err error, code int) {
+			rw.WriteHeader(code)
+			rw.Write([]byte("error: " + err.Error()))
+		}),
+	)
+	r.Get("/panic", func(rw http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+	c := enginetest.New(t, r)
+	c.Get("/missing").Do().
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("Request-Id", "req-1").
+		AssertBody("error: Not Found")
+	c.Get("/panic").Do().
+		AssertStatus(http.StatusInternalServerError).
+		AssertBody("error: Ooops. Something went wrong on our end")
+	if !strings.Contains(out.String(), "request_id=req-1") || !strings.Contains(out.String(), "boom") {
+		t.Errorf("logger wasn't used: %s", out.String())
+	}
+}
+
+func TestNewRouterStrict(t *testing.T) {
+	for _, paths := range [][2]string{
+		{"/users/:id", "/users/:name"},
+		{"/users", "/users/"},
+		{"/users/:id", "/users/{id}"},
+		{"/Users", "/users"},
+	} {
+		r := engine.NewRouter(engine.Strict(), engine.CaseInsensitive())
+		r.Get(paths[0], nop)
+		func() {
+			defer func() {
+				msg, _ := recover().(string)
+				if !strings.Contains(msg, "GET "+paths[1]) || !strings.Contains(msg, "conflicts with GET "+paths[0]) || !strings.Contains(msg, "options_test.go") {
+					t.Errorf("registering %s after %s panicked with %q", paths[1], paths[0], msg)
+				}
+			}()
+			r.Get(paths[1], nop)
+		}()
+	}
+
+	r := engine.NewRouter(engine.Strict())
+	r.Get("/users/:id", nop)
+	r.Get("/users/:id/posts", nop)
+	r.Post("/users/:id", nop)
+	r.Get("/users/me", nop)
+}
@@ -0,0 +1,269 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for cursors that are malformed or weren't
+// signed with the configured secret.
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// PaginationConfig configures ParsePagination.
+type PaginationConfig struct {
+	// DefaultLimit is the page size when none is requested. Defaults to 20.
+	DefaultLimit int
+	// MaxLimit is the largest page size that may be requested. Defaults to 100.
+	MaxLimit int
+	// Secret signs cursors so clients can't forge or modify them. Cursors
+	// can't be used without one.
+	Secret []byte
+}
+
+// DefaultPaginationConfig is used by ParsePagination when no config is given.
+var DefaultPaginationConfig = &PaginationConfig{}
+
+// Pagination is the page of a list requested by a client, either with
+// ?page=&per_page=, ?offset=&limit= or ?cursor=&limit=.
+type Pagination struct {
+	// Page is the 1-based page number. It's zero when a cursor is used.
+	Page   int
+	Offset int
+	Limit  int
+	// Cursor is the raw cursor sent by the client, if any. Use DecodeCursor
+	// to read it.
+	Cursor string
+
+	config  *PaginationConfig
+	req     *http.Request
+	byPage  bool
+	sizeKey string
+}
+
+// ParsePagination parses and validates the pagination parameters of req.
+// Errors are suitable for returning to the client with a 400.
+func ParsePagination(req *http.Request, config *PaginationConfig) (*Pagination, error) {
+	if config == nil {
+		config = DefaultPaginationConfig
+	}
+	q := req.URL.Query()
+	p := &Pagination{config: config, req: req, Limit: config.defaultLimit()}
+
+	p.sizeKey = "limit"
+	if _, ok := q["per_page"]; ok {
+		p.sizeKey = "per_page"
+	}
+	if v := q.Get(p.sizeKey); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("%s must be a positive integer", p.sizeKey)
+		}
+		if limit > config.maxLimit() {
+			return nil, fmt.Errorf("%s must not be greater than %d", p.sizeKey, config.maxLimit())
+		}
+		p.Limit = limit
+	}
+
+	switch {
+	case q.Get("cursor") != "":
+		if len(config.Secret) == 0 {
+			return nil, errors.New("cursor is not supported")
+		}
+		p.Cursor = q.Get("cursor")
+		if err := p.DecodeCursor(nil); err != nil {
+			return nil, err
+		}
+	case q.Get("offset") != "":
+		offset, err := strconv.Atoi(q.Get("offset"))
+		if err != nil || offset < 0 {
+			return nil, errors.New("offset must be a non-negative integer")
+		}
+		if max := math.MaxInt - p.Limit; offset > max {
+			return nil, fmt.Errorf("offset must not be greater than %d", max)
+		}
+		p.Offset = offset
+	default:
+		p.byPage, p.Page = true, 1
+		if p.sizeKey == "limit" && q.Get("limit") == "" {
+			p.sizeKey = "per_page"
+		}
+		if v := q.Get("page"); v != "" {
+			page, err := strconv.Atoi(v)
+			if err != nil || page < 1 {
+				return nil, errors.New("page must be a positive integer")
+			}
+			// The offset of the page after it must fit in an int too.
+			if max := math.MaxInt/p.Limit - 1; page > max {
+				return nil, fmt.Errorf("page must not be greater than %d", max)
+			}
+			p.Page = page
+		}
+		p.Offset = (p.Page - 1) * p.Limit
+	}
+	return p, nil
+}
+
+func (c *PaginationConfig) defaultLimit() int {
+	if c.DefaultLimit == 0 {
+		return 20
+	}
+	return c.DefaultLimit
+}
+
+func (c *PaginationConfig) maxLimit() int {
+	if c.MaxLimit == 0 {
+		return 100
+	}
+	return c.MaxLimit
+}
+
+// EncodeCursor returns a signed, opaque cursor holding v as JSON.
+func (c *PaginationConfig) EncodeCursor(v interface{}) (string, error) {
+	if len(c.Secret) == 0 {
+		return "", errors.New("engine: pagination cursors need a Secret")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(c.sign(data)), nil
+}
+
+func (c *PaginationConfig) sign(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+// DecodeCursor verifies the request's cursor and decodes it into v.
+func (p *Pagination) DecodeCursor(v interface{}) error {
+	parts := strings.SplitN(p.Cursor, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, p.config.sign(data)) {
+		return ErrInvalidCursor
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// SetLinkHeader sets an RFC 8288 Link header with first, prev, next and, if
+// total isn't negative, last links for offset or page based pagination.
+func (p *Pagination) SetLinkHeader(rw http.ResponseWriter, total int) {
+	var links []string
+	if p.byPage {
+		links = append(links, p.link("first", "page", 1))
+		if p.Page > 1 {
+			links = append(links, p.link("prev", "page", p.Page-1))
+		}
+		if total < 0 || p.Page*p.Limit < total {
+			links = append(links, p.link("next", "page", p.Page+1))
+		}
+		if total >= 0 {
+			links = append(links, p.link("last", "page", lastPage(total, p.Limit)))
+		}
+	} else {
+		links = append(links, p.link("first", "offset", 0))
+		if p.Offset > 0 {
+			prev := p.Offset - p.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links = append(links, p.link("prev", "offset", prev))
+		}
+		if total < 0 || p.Offset+p.Limit < total {
+			links = append(links, p.link("next", "offset", p.Offset+p.Limit))
+		}
+		if total >= 0 {
+			links = append(links, p.link("last", "offset", (lastPage(total, p.Limit)-1)*p.Limit))
+		}
+	}
+	rw.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// SetCursorLinkHeader sets an RFC 8288 Link header with next and prev links
+// for cursor based pagination. Empty cursors are omitted.
+func (p *Pagination) SetCursorLinkHeader(rw http.ResponseWriter, next, prev string) {
+	var links []string
+	if prev != "" {
+		links = append(links, p.cursorLink("prev", prev))
+	}
+	if next != "" {
+		links = append(links, p.cursorLink("next", next))
+	}
+	if len(links) > 0 {
+		rw.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// Meta returns pagination metadata for a response envelope. Pass a negative
+// total if it isn't known.
+func (p *Pagination) Meta(total int) J {
+	meta := J{p.sizeKey: p.Limit}
+	if p.byPage {
+		meta["page"] = p.Page
+	} else if p.Cursor == "" {
+		meta["offset"] = p.Offset
+	}
+	if total >= 0 {
+		meta["total"] = total
+		if p.byPage {
+			meta["total_pages"] = lastPage(total, p.Limit)
+		}
+	}
+	return meta
+}
+
+// JSONPage writes data in a {"data": ..., "meta": ...} envelope.
+func JSONPage(rw http.ResponseWriter, data interface{}, meta J, code int) {
+	JSON(rw, J{"data": data, "meta": meta}, code)
+}
+
+func (p *Pagination) link(rel, key string, value int) string {
+	q := p.req.URL.Query()
+	q.Del("cursor")
+	q.Set(key, strconv.Itoa(value))
+	q.Set(p.sizeKey, strconv.Itoa(p.Limit))
+	return p.format(rel, q)
+}
+
+func (p *Pagination) cursorLink(rel, cursor string) string {
+	q := p.req.URL.Query()
+	q.Del("page")
+	q.Del("offset")
+	q.Set("cursor", cursor)
+	q.Set(p.sizeKey, strconv.Itoa(p.Limit))
+	return p.format(rel, q)
+}
+
+func (p *Pagination) format(rel string, q url.Values) string {
+	u := url.URL{Path: p.req.URL.Path, RawQuery: q.Encode()}
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+}
+
+func lastPage(total, limit int) int {
+	if total == 0 {
+		return 1
+	}
+	return (total + limit - 1) / limit
+}
@@ -0,0 +1,92 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		query               string
+		page, offset, limit int
+		err                 string
+	}{
+		{"", 1, 0, 20, ""},
+		{"?page=3&per_page=10", 3, 20, 10, ""},
+		{"?offset=15&limit=5", 0, 15, 5, ""},
+		{"?page=0", 0, 0, 0, "page must be a positive integer"},
+		{"?page=x", 0, 0, 0, "page must be a positive integer"},
+		{"?offset=-1", 0, 0, 0, "offset must be a non-negative integer"},
+		{"?limit=0", 0, 0, 0, "limit must be a positive integer"},
+		{"?per_page=101", 0, 0, 0, "per_page must not be greater than 100"},
+		{"?cursor=abc", 0, 0, 0, "cursor is not supported"},
+		{"?page=" + strconv.Itoa(math.MaxInt/20-1), math.MaxInt/20 - 1, (math.MaxInt/20 - 2) * 20, 20, ""},
+		{"?page=" + strconv.Itoa(math.MaxInt/20), 0, 0, 0, "page must not be greater than " + strconv.Itoa(math.MaxInt/20-1)},
+		{"?page=" + strconv.Itoa(math.MaxInt) + "&per_page=100", 0, 0, 0, "page must not be greater than " + strconv.Itoa(math.MaxInt/100-1)},
+		{"?offset=" + strconv.Itoa(math.MaxInt-20), 0, math.MaxInt - 20, 20, ""},
+		{"?offset=" + strconv.Itoa(math.MaxInt), 0, 0, 0, "offset must not be greater than " + strconv.Itoa(math.MaxInt-20)},
+		{"?offset=99999999999999999999", 0, 0, 0, "offset must be a non-negative integer"},
+	}
+	for _, tt := range tests {
+		p, err := engine.ParsePagination(httptest.NewRequest("GET", "/items"+tt.query, nil), nil)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: error = %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		if p.Page != tt.page || p.Offset != tt.offset || p.Limit != tt.limit {
+			t.Errorf("%q: page %d offset %d limit %d, want %d %d %d", tt.query, p.Page, p.Offset, p.Limit, tt.page, tt.offset, tt.limit)
+		}
+	}
+}
+
+func TestPaginationLinks(t *testing.T) {
+	p, _ := engine.ParsePagination(httptest.NewRequest("GET", "/items?page=2&per_page=10&q=x", nil), nil)
+	rec := httptest.NewRecorder()
+	p.SetLinkHeader(rec, 35)
+	want := `</items?page=1&per_page=10&q=x>; rel="first", </items?page=1&per_page=10&q=x>; rel="prev", ` +
+		`</items?page=3&per_page=10&q=x>; rel="next", </items?page=4&per_page=10&q=x>; rel="last"`
+	if got := rec.Header().Get("Link"); got != want {
+		t.Errorf("Link = %s, want %s", got, want)
+	}
+	if meta := p.Meta(35); meta["page"] != 2 || meta["per_page"] != 10 || meta["total_pages"] != 4 {
+		t.Errorf("Meta = %v", meta)
+	}
+
+	p, _ = engine.ParsePagination(httptest.NewRequest("GET", "/items?offset=5&limit=10", nil), nil)
+	rec = httptest.NewRecorder()
+	p.SetLinkHeader(rec, -1)
+	want = `</items?limit=10&offset=0>; rel="first", </items?limit=10&offset=0>; rel="prev", </items?limit=10&offset=15>; rel="next"`
+	if got := rec.Header().Get("Link"); got != want {
+		t.Errorf("Link = %s, want %s", got, want)
+	}
+}
+
+func TestPaginationCursor(t *testing.T) {
+	config := &engine.PaginationConfig{Secret: []byte("secret")}
+	cursor, err := config.EncodeCursor(engine.J{"after": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := engine.ParsePagination(httptest.NewRequest("GET", "/items?cursor="+cursor, nil), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ After int }
+	if err := p.DecodeCursor(&got); err != nil || got.After != 42 {
+		t.Errorf("DecodeCursor = %+v, %v", got, err)
+	}
+
+	other := &engine.PaginationConfig{Secret: []byte("other")}
+	if _, err := engine.ParsePagination(httptest.NewRequest("GET", "/items?cursor="+cursor, nil), other); err != engine.ErrInvalidCursor {
+		t.Errorf("cursor signed with another secret: error = %v, want ErrInvalidCursor", err)
+	}
+}
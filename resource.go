@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Indexer is implemented by resource controllers that list the collection,
+// served at GET /things.
+type Indexer interface {
+	Index(http.ResponseWriter, *http.Request)
+}
+
+// Creator is implemented by resource controllers that add to the collection,
+// served at POST /things.
+type Creator interface {
+	Create(http.ResponseWriter, *http.Request)
+}
+
+// Shower is implemented by resource controllers that show a member, served at
+// GET /things/:thing_id.
+type Shower interface {
+	Show(http.ResponseWriter, *http.Request)
+}
+
+// Updater is implemented by resource controllers that replace a member,
+// served at PUT /things/:thing_id.
+type Updater interface {
+	Update(http.ResponseWriter, *http.Request)
+}
+
+// Patcher is implemented by resource controllers that partially update a
+// member, served at PATCH /things/:thing_id.
+type Patcher interface {
+	Patch(http.ResponseWriter, *http.Request)
+}
+
+// Destroyer is implemented by resource controllers that delete a member,
+// served at DELETE /things/:thing_id.
+type Destroyer interface {
+	Destroy(http.ResponseWriter, *http.Request)
+}
+
+// ActionMiddleware is implemented by resource controllers with middleware for
+// some of their actions. It's called with each action's name, such as "Create"
+// or the name of a member or collection action, when its route is registered.
+type ActionMiddleware interface {
+	Middleware(action string) []MiddlewareFunc
+}
+
+// ResourceParam is implemented by resource controllers naming their member
+// param, instead of the singular of the resource's path followed by "_id".
+type ResourceParam interface {
+	Param() string
+}
+
+// Resource is a collection of REST routes generated from a controller by
+// Router.Resource.
+type Resource struct {
+	router     *Router
+	controller interface{}
+	name       string
+	param      string
+
+	mu     sync.Mutex
+	routes []*Route
+}
+
+// Resource registers the conventional REST routes for the actions controller
+// implements, wrapped in middleware. For "/users" they are
+//
+//	GET    /users               Index
+//	POST   /users               Create
+//	GET    /users/:user_id      Show
+//	PUT    /users/:user_id      Update
+//	PATCH  /users/:user_id      Patch
+//	DELETE /users/:user_id      Destroy
+//
+// Routes' Action fields name the controller and action, e.g. "Users.Index".
+// Nested resources, such as /users/:user_id/posts, are registered with the
+// returned Resource.
+func (r *Router) Resource(path string, controller interface{}, middleware ...MiddlewareFunc) *Resource {
+	collection := r.calculateAbsolutePath(path)
+	res := &Resource{
+		router:     r.SubRouter(collection, middleware...),
+		controller: controller,
+		name:       reflect.Indirect(reflect.ValueOf(controller)).Type().Name(),
+		param:      resourceParam(collection, controller),
+	}
+	member := "/:" + res.param
+	if c, ok := controller.(Indexer); ok {
+		res.handle("GET", "", "Index", c.Index, nil)
+	}
+	if c, ok := controller.(Creator); ok {
+		res.handle("POST", "", "Create", c.Create, nil)
+	}
+	if c, ok := controller.(Shower); ok {
+		res.handle("GET", member, "Show", c.Show, nil)
+	}
+	if c, ok := controller.(Updater); ok {
+		res.handle("PUT", member, "Update", c.Update, nil)
+	}
+	if c, ok := controller.(Patcher); ok {
+		res.handle("PATCH", member, "Patch", c.Patch, nil)
+	}
+	if c, ok := controller.(Destroyer); ok {
+		res.handle("DELETE", member, "Destroy", c.Destroy, nil)
+	}
+	return res
+}
+
+// Param returns the name of the resource's member param, e.g. "user_id".
+func (res *Resource) Param() string {
+	return res.param
+}
+
+// Routes returns the routes registered for the resource, not including those
+// of nested resources.
+func (res *Resource) Routes() []*Route {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	return append([]*Route(nil), res.routes...)
+}
+
+// Member registers an action on members of the resource, e.g. a "publish"
+// action at POST /posts/:post_id/publish.
+func (res *Resource) Member(method, name string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return res.handle(method, "/:"+res.param+"/"+name, name, handler, middleware)
+}
+
+// Collection registers an action on the collection, e.g. a "search" action at
+// GET /posts/search.
+func (res *Resource) Collection(method, name string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return res.handle(method, "/"+name, name, handler, middleware)
+}
+
+// Resource registers a resource nested under members of this one, e.g.
+// "/posts" under /users is served at /users/:user_id/posts. Its routes are
+// wrapped in this resource's middleware too.
+func (res *Resource) Resource(path string, controller interface{}, middleware ...MiddlewareFunc) *Resource {
+	return res.router.SubRouter(res.router.calculateAbsolutePath("/:"+res.param)).Resource(path, controller, middleware...)
+}
+
+func (res *Resource) handle(method, path, action string, handler http.HandlerFunc, middleware []MiddlewareFunc) *Route {
+	if am, ok := res.controller.(ActionMiddleware); ok {
+		middleware = append(am.Middleware(action), middleware...)
+	}
+	rt := res.router.Handle(method, path, handler, middleware...)
+	rt.Action = res.name + "." + action
+	res.mu.Lock()
+	res.routes = append(res.routes, rt)
+	res.mu.Unlock()
+	return rt
+}
+
+// resourceParam returns the member param of the resource at collection.
+func resourceParam(collection string, controller interface{}) string {
+	if c, ok := controller.(ResourceParam); ok {
+		return c.Param()
+	}
+	name := []byte(singular(path.Base(collection)))
+	for i, c := range name {
+		if !isParamNameByte(c) {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] == '_' {
+		return "id"
+	}
+	return string(name) + "_id"
+}
+
+// singular returns the singular of an English plural noun, for the common
+// cases.
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "sses"), strings.HasSuffix(name, "xes"), strings.HasSuffix(name, "ches"), strings.HasSuffix(name, "shes"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss"):
+		return name[:len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,90 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type Users struct{}
+
+func (Users) Index(rw http.ResponseWriter, req *http.Request) {
+	rw.Write([]byte("index"))
+}
+
+func (Users) Show(rw http.ResponseWriter, req *http.Request) {
+	rw.Write([]byte("show " + engine.GetContext(req).Params.ByName("user_id")))
+}
+
+func (Users) Destroy(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (Users) Middleware(action string) []engine.MiddlewareFunc {
+	if action == "Destroy" {
+		return []engine.MiddlewareFunc{record("admin")}
+	}
+	return nil
+}
+
+type Posts struct{}
+
+func (*Posts) Index(rw http.ResponseWriter, req *http.Request) {
+	rw.Write([]byte("posts of " + engine.GetContext(req).Params.ByName("user_id")))
+}
+
+func (*Posts) Create(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(http.StatusCreated)
+}
+
+func (*Posts) Show(rw http.ResponseWriter, req *http.Request) {
+	params := engine.GetContext(req).Params
+	rw.Write([]byte(params.ByName("user_id") + "/" + params.ByName("post_id")))
+}
+
+func TestResource(t *testing.T) {
+	r := engine.NewRouter()
+	users := r.Resource("/users", Users{}, record("users"))
+	users.Collection("GET", "search", writeMethod)
+	posts := users.Resource("/posts", &Posts{})
+	posts.Member("POST", "publish", writeMethod)
+
+	c := enginetest.New(t, r)
+	c.Get("/users").Do().AssertStatus(http.StatusOK).AssertBody("index").AssertHeader("X-Order", "users")
+	c.Get("/users/search").Do().AssertStatus(http.StatusOK).AssertBody("GET")
+	c.Get("/users/42").Do().AssertBody("show 42")
+	res := c.Delete("/users/42").Do().AssertStatus(http.StatusNoContent)
+	if got, want := res.Header()["X-Order"], []string{"users", "admin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("middleware order = %v, want %v", got, want)
+	}
+	c.Post("/users").Do().AssertStatus(http.StatusMethodNotAllowed)
+	c.Get("/users/42/posts").Do().AssertBody("posts of 42").AssertHeader("X-Order", "users")
+	c.Post("/users/42/posts").Do().AssertStatus(http.StatusCreated)
+	c.Get("/users/42/posts/7").Do().AssertBody("42/7")
+	c.Post("/users/42/posts/7/publish").Do().AssertBody("POST")
+
+	var table []string
+	for _, rt := range r.Routes() {
+		table = append(table, rt.Method+" "+rt.Path+" "+rt.Action)
+	}
+	sort.Strings(table)
+	want := []string{
+		"DELETE /users/:user_id Users.Destroy",
+		"GET /users Users.Index",
+		"GET /users/:user_id Users.Show",
+		"GET /users/:user_id/posts Posts.Index",
+		"GET /users/:user_id/posts/:post_id Posts.Show",
+		"GET /users/search Users.search",
+		"POST /users/:user_id/posts Posts.Create",
+		"POST /users/:user_id/posts/:post_id/publish Posts.publish",
+	}
+	if !reflect.DeepEqual(table, want) {
+		t.Errorf("routes = %q, want %q", table, want)
+	}
+	if got := len(posts.Routes()); got != 4 {
+		t.Errorf("len(posts.Routes()) = %d, want 4", got)
+	}
+}
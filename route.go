@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Route is a registered route. Its methods describe the route for the
+// router's OpenAPI document and return the Route so they can be chained.
+//
+//	r.Post("/users", createUser).
+//		Summary("Create a user").
+//		Request(CreateUserRequest{}).
+//		Response(http.StatusCreated, User{})
+type Route struct {
+	Method string
+	Path   string
+	// Version is the API version the route belongs to, if it was registered
+	// on a router returned by Versions.Version.
+	Version string
+	// Action names the controller and action the route was generated for by
+	// Router.Resource, such as "Users.Index".
+	Action string
+
+	mu          sync.Mutex
+	summary     string
+	description string
+	operationID string
+	tags        []string
+	request     reflect.Type
+	responses   map[int]reflect.Type
+	security    []string
+	securitySet bool
+	deprecated  bool
+	hidden      bool
+	debug       atomic.Bool
+	quiet       atomic.Bool
+	versions    *Versions
+	source      string
+	router      *Router
+}
+
+// Summary sets a short summary of what the route does.
+func (rt *Route) Summary(summary string) *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.summary = summary
+	return rt
+}
+
+// Description sets a longer description of the route. CommonMark may be used.
+func (rt *Route) Description(description string) *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.description = description
+	return rt
+}
+
+// OperationID sets the route's unique operation ID.
+func (rt *Route) OperationID(id string) *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.operationID = id
+	return rt
+}
+
+// Tags adds tags used to group the route with others.
+func (rt *Route) Tags(tags ...string) *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.tags = append(rt.tags, tags...)
+	return rt
+}
+
+// Request sets the struct the route binds its request to, as used with Bind,
+// BindQuery, BindHeaders or BindForm. Fields tagged param, query and header
+// become parameters and the rest the request body.
+func (rt *Route) Request(v interface{}) *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.request = reflect.TypeOf(v)
+	return rt
+}
+
+// Response adds a response with the given status code whose JSON body is
+// like v. A nil v means the response has no body.
+func (rt *Route) Response(code int, v interface{}) *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.responses == nil {
+		rt.responses = make(map[int]reflect.Type)
+	}
+	rt.responses[code] = reflect.TypeOf(v)
+	return rt
+}
+
+// Security replaces the security schemes inherited from the router's
+// UseSecurity calls. Call it with no names to mark the route as public.
+func (rt *Route) Security(names ...string) *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.security = append([]string{}, names...)
+	rt.securitySet = true
+	return rt
+}
+
+// securitySchemes returns the names set with Security or, if it wasn't
+// called, those the route's router requires. The caller holds rt.mu.
+func (rt *Route) securitySchemes() []string {
+	if rt.securitySet || rt.router == nil {
+		return rt.security
+	}
+	return rt.router.allSecurity()
+}
+
+// Deprecated marks the route as deprecated.
+func (rt *Route) Deprecated() *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.deprecated = true
+	return rt
+}
+
+// Hidden leaves the route out of the OpenAPI document.
+func (rt *Route) Hidden() *Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.hidden = true
+	return rt
+}
+
+// Debug turns debug level logging on or off for requests to the route,
+// whatever the logger's level. It's safe to call while serving requests.
+func (rt *Route) Debug(enabled bool) *Route {
+	rt.debug.Store(enabled)
+	return rt
+}
+
+// Quiet leaves successful requests to the route out of the access log, for
+// frequently polled routes such as health probes. Requests that fail with a
+// 5xx are still logged.
+func (rt *Route) Quiet() *Route {
+	rt.quiet.Store(true)
+	return rt
+}
+
+// SecurityScheme describes how clients authenticate, as an OpenAPI security
+// scheme object.
+type SecurityScheme struct {
+	// Type is one of "apiKey", "http", "oauth2" or "openIdConnect".
+	Type string `json:"type"`
+	// Scheme is the HTTP authorization scheme, such as "bearer" or "basic".
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	// In and Name locate an API key, e.g. "header" and "X-API-Key".
+	In               string `json:"in,omitempty"`
+	Name             string `json:"name,omitempty"`
+	OpenIDConnectURL string `json:"openIdConnectUrl,omitempty"`
+	Description      string `json:"description,omitempty"`
+}
+
+// routeTable records the routes, hosts and fallback handlers registered on a
+// router and its sub routers.
+type routeTable struct {
+	mu      sync.Mutex
+	routes  []*Route
+	schemes map[string]*SecurityScheme
+	hosts   []*hostRouter
+	options *routerOptions
+	// generation counts calls to Use, so routes rewrap their handlers.
+	generation atomic.Int64
+
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+}
+
+func (t *routeTable) add(rt *Route) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = append(t.routes, rt)
+}
+
+// Routes returns the routes registered on the router and its sub routers, in
+// the order they were registered.
+func (r *Router) Routes() []*Route {
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	return append([]*Route(nil), r.routes.routes...)
+}
+
+// SecurityScheme registers a security scheme that routes can refer to by name
+// with Route.Security.
+func (r *Router) SecurityScheme(name string, scheme *SecurityScheme) {
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	if r.routes.schemes == nil {
+		r.routes.schemes = make(map[string]*SecurityScheme)
+	}
+	r.routes.schemes[name] = scheme
+}
+
+// UseSecurity adds auth middleware to the router and records that its routes,
+// including those of its sub routers and those registered before, require the
+// named security scheme.
+func (r *Router) UseSecurity(name string, scheme *SecurityScheme, middleware MiddlewareFunc) {
+	r.SecurityScheme(name, scheme)
+	r.Use(middleware)
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	r.security = append(r.security, name)
+}
@@ -4,72 +4,189 @@ import (
 	"bufio"
 	"errors"
 	"github.com/julienschmidt/httprouter"
-	"golang.org/x/net/context"
 	"net"
 	"net/http"
 	"path"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
 type Router struct {
-	mux          *httprouter.Router
+	mux          *treeMux
+	routes       *routeTable
 	absolutePath string
-	middleware   []MiddlewareFunc
+	middleware   []*middlewareEntry
+	security     []string
+	version      *apiVersion
+	parent       *Router
+	fallback     http.Handler
+}
+
+// middlewareEntry is middleware added to a router. Each route calls its
+// MiddlewareFunc once, however often the route's chain is recomposed.
+type middlewareEntry struct {
+	fn MiddlewareFunc
+}
+
+func newMiddlewareEntries(middleware []MiddlewareFunc) []*middlewareEntry {
+	entries := make([]*middlewareEntry, len(middleware))
+	for i, m := range middleware {
+		entries[i] = &middlewareEntry{fn: m}
+	}
+	return entries
 }
 
 func (r *Router) ListMiddleware() (mi []string) {
-	for _, m := range r.middleware {
-		mi = append(mi, runtime.FuncForPC(reflect.ValueOf(m).Pointer()).Name())
+	for _, m := range r.allMiddleware() {
+		mi = append(mi, runtime.FuncForPC(reflect.ValueOf(m.fn).Pointer()).Name())
 	}
 	return mi
 }
 
 func notFound(rw http.ResponseWriter, req *http.Request) {
-	JSON(rw, J{"status_code": http.StatusNotFound, "message": http.StatusText(http.StatusNotFound)}, http.StatusNotFound)
-	return
+	RenderError(rw, req, nil, http.StatusNotFound)
 }
 
-func NewRouter() *Router {
-	r := httprouter.New()
-	r.NotFound = MetadataMiddleware(http.HandlerFunc(notFound))
-	return &Router{mux: r, middleware: []MiddlewareFunc{MetadataMiddleware}}
+func methodNotAllowed(rw http.ResponseWriter, req *http.Request) {
+	RenderError(rw, req, nil, http.StatusMethodNotAllowed)
+}
+
+// NewRouter returns a router configured by opts. With none, its middleware is
+// MetadataMiddleware and it redirects requests to fixed paths.
+func NewRouter(opts ...Option) *Router {
+	options := defaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return newRouter(options)
+}
+
+func newRouter(options routerOptions) *Router {
+	mux := newTreeMux()
+	mux.RedirectTrailingSlash = options.redirectTrailingSlash
+	mux.RedirectFixedPath = options.redirectFixedPath
+	mux.CaseInsensitive = options.caseInsensitive
+	mux.PanicHandler = options.panicHandler
+	r := &Router{
+		mux: mux,
+		routes: &routeTable{
+			notFound:         http.HandlerFunc(notFound),
+			methodNotAllowed: http.HandlerFunc(methodNotAllowed),
+			options:          &options,
+		},
+		middleware: newMiddlewareEntries(options.middleware),
+	}
+	r.fallback = r.lazyChain(http.HandlerFunc(serveFallback), nil)
+	mux.NotFound = http.HandlerFunc(r.unmatched)
+	return r
 }
 
+// SetNotFound sets the handler for requests that match no route. It's wrapped
+// in the router's middleware.
 func (r *Router) SetNotFound(h http.Handler) {
-	r.mux.NotFound = h
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	r.routes.notFound = h
 }
 
+// SetMethodNotAllowed sets the handler for requests whose path has routes,
+// but not for the request's method. It's wrapped in the router's middleware,
+// and the Allow header is set before it's called.
 func (r *Router) SetMethodNotAllowed(h http.Handler) {
-	r.mux.MethodNotAllowed = h
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	r.routes.methodNotAllowed = h
+}
+
+func (r *Router) notFoundHandler() http.Handler {
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	return r.routes.notFound
 }
 
 func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	GetContext(req).router = r
+	if r.serveHost(rw, req) {
+		return
+	}
+	if r.routes.options.cleanPath {
+		req.URL.Path = httprouter.CleanPath(req.URL.Path)
+	}
 	r.mux.ServeHTTP(rw, req)
 }
 
+// SubRouter returns a router whose routes are wrapped in this router's
+// middleware, including any added later, and then the given middleware.
 func (r *Router) SubRouter(relativePath string, middleware ...MiddlewareFunc) *Router {
-	middleware = append(r.middleware, middleware...)
 	sr := &Router{
 		mux:          r.mux,
+		routes:       r.routes,
 		absolutePath: relativePath,
-		middleware:   middleware,
+		middleware:   newMiddlewareEntries(middleware),
+		version:      r.version,
+		parent:       r,
 	}
 	return sr
 }
 
+// Use adds middleware to the router. It applies to all of the router's routes
+// and its sub routers' routes, whether they were registered before or after.
 func (r *Router) Use(middleware ...MiddlewareFunc) {
-	r.middleware = append(r.middleware, middleware...)
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	r.middleware = append(r.middleware, newMiddlewareEntries(middleware)...)
+	r.routes.generation.Add(1)
 }
 
+// allMiddleware returns the middleware of the router and its parents,
+// outermost first.
+func (r *Router) allMiddleware() []*middlewareEntry {
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	var all []*middlewareEntry
+	for sr := r; sr != nil; sr = sr.parent {
+		all = append(append([]*middlewareEntry(nil), sr.middleware...), all...)
+	}
+	return all
+}
+
+// allSecurity returns the security schemes added with UseSecurity to the
+// router and its parents.
+func (r *Router) allSecurity() []string {
+	r.routes.mu.Lock()
+	defer r.routes.mu.Unlock()
+	var all []string
+	for sr := r; sr != nil; sr = sr.parent {
+		all = append(append([]string(nil), sr.security...), all...)
+	}
+	return all
+}
+
+// Static serves the files under the root directory at relativePath. Use
+// StaticFS with StaticConfig.Listing for directory listings.
 func (r *Router) Static(relativePath, root string) {
-	absolutePath := r.calculateAbsolutePath(relativePath)
-	absolutePath = path.Join(absolutePath, "/*filepath")
+	r.StaticFS(relativePath, http.Dir(root), nil)
+}
 
-	r.mux.ServeFiles(absolutePath, http.Dir(root))
+// StaticFS serves fs at relativePath. Use http.FS to serve an fs.FS such as an
+// embed.FS. Missing files are rendered by the router's NotFound handler.
+func (r *Router) StaticFS(relativePath string, fs http.FileSystem, config *StaticConfig) {
+	if config == nil {
+		config = &StaticConfig{}
+	}
+	handler := &fileServer{
+		root:     fs,
+		config:   *config,
+		notFound: r.notFoundHandler,
+	}
+	absolutePath := path.Join(r.calculateAbsolutePath(relativePath), "/*filepath")
+	r.Handle("GET", absolutePath, handler).Hidden()
+	r.Handle("HEAD", absolutePath, handler).Hidden()
 }
 
 func (r *Router) calculateAbsolutePath(relativePath string) string {
@@ -85,73 +202,135 @@ func (r *Router) calculateAbsolutePath(relativePath string) string {
 
 }
 
+// UseHandler adds handler to the router's middleware with HandlerMiddleware,
+// so the rest of the chain is skipped once it writes a response.
 func (r *Router) UseHandler(handler http.Handler) {
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-			handler.ServeHTTP(rw, req)
-			next.ServeHTTP(rw, req)
-		})
-	})
+	r.Use(HandlerMiddleware(handler))
+}
+
+// Handle registers a handler for the given method and path, wrapped in the
+// router's middleware and then the given middleware.
+//
+// Path segments may be static, params such as :id, {id}, {id:[0-9]+} or
+// :name.png, optional trailing params such as :id?, or a final catch-all such
+// as *path. Static segments take precedence over params, so /users/new and
+// /users/:id can both be registered.
+func (r *Router) Handle(method, path string, handler http.Handler, middleware ...MiddlewareFunc) *Route {
+	chained := r.lazyChain(handler, append([]MiddlewareFunc(nil), middleware...))
+	var route *Route
+	if r.version != nil {
+		route = r.version.handle(method, r.calculateAbsolutePath(path), chained, r)
+	} else {
+		absolutePath := r.calculateAbsolutePath(path)
+		route = &Route{Method: method, Path: absolutePath, router: r, source: caller()}
+		r.register(method, absolutePath, wrap(chained, route))
+		r.routes.add(route)
+	}
+	if d, ok := handler.(interface{ describe(*Route) }); ok {
+		d.describe(route)
+	}
+	return route
+}
+
+// chained is a route's handler composed with its middleware as of a
+// routeTable generation.
+type chained struct {
+	generation int64
+	handler    http.Handler
+}
+
+// handlerSlot is the next handler given to middleware, which can be pointed
+// at another handler when the chain is recomposed.
+type handlerSlot struct {
+	next atomic.Pointer[http.Handler]
 }
 
-func (r *Router) Handle(method, path string, handler http.Handler, middleware ...MiddlewareFunc) {
-	absolutePath := r.calculateAbsolutePath(path)
+func (s *handlerSlot) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	(*s.next.Load()).ServeHTTP(rw, req)
+}
+
+// lazyChain wraps handler in middleware and then, when it first serves a
+// request, in the router's middleware. The chain is recomposed after
+// middleware is added with Use, but each MiddlewareFunc is only called once.
+func (r *Router) lazyChain(handler http.Handler, middleware []MiddlewareFunc) http.Handler {
 	for i := len(middleware) - 1; i >= 0; i-- {
 		handler = middleware[i](handler)
 	}
-	for i := len(r.middleware) - 1; i >= 0; i-- {
-		handler = r.middleware[i](handler)
-	}
-	r.mux.Handle(method, absolutePath, wrap(handler))
+	var mu sync.Mutex
+	var current atomic.Pointer[chained]
+	slots := make(map[*middlewareEntry]*handlerSlot)
+	built := make(map[*middlewareEntry]http.Handler)
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		generation := r.routes.generation.Load()
+		c := current.Load()
+		if c == nil || c.generation != generation {
+			mu.Lock()
+			if c = current.Load(); c == nil || c.generation != generation {
+				next := handler
+				entries := r.allMiddleware()
+				for i := len(entries) - 1; i >= 0; i-- {
+					e := entries[i]
+					if slots[e] == nil {
+						slots[e] = &handlerSlot{}
+						built[e] = e.fn(slots[e])
+					}
+					n := next
+					slots[e].next.Store(&n)
+					next = built[e]
+				}
+				c = &chained{generation: generation, handler: next}
+				current.Store(c)
+			}
+			mu.Unlock()
+		}
+		c.handler.ServeHTTP(rw, req)
+	})
 }
 
-func (r *Router) HandleFunc(method, path string, handler func(http.ResponseWriter, *http.Request), middleware ...MiddlewareFunc) {
-	r.Handle(method, path, http.HandlerFunc(handler), middleware...)
+func (r *Router) HandleFunc(method, path string, handler func(http.ResponseWriter, *http.Request), middleware ...MiddlewareFunc) *Route {
+	return r.Handle(method, path, http.HandlerFunc(handler), middleware...)
 }
 
 // Get registers a GET handler for the given path.
-func (r *Router) Get(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) {
-	r.HandleFunc("GET", path, handler, middleware...)
+func (r *Router) Get(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return r.HandleFunc("GET", path, handler, middleware...)
 }
 
-func (r *Router) Head(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) {
-	r.HandleFunc("HEAD", path, handler, middleware...)
+func (r *Router) Head(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return r.HandleFunc("HEAD", path, handler, middleware...)
 }
 
 // Put registers a PUT handler for the given path.
-func (r *Router) Put(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) {
-	r.HandleFunc("PUT", path, handler, middleware...)
+func (r *Router) Put(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return r.HandleFunc("PUT", path, handler, middleware...)
 }
 
 // Post registers a POST handler for the given path.
-func (r *Router) Post(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) {
-	r.HandleFunc("POST", path, handler, middleware...)
+func (r *Router) Post(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return r.HandleFunc("POST", path, handler, middleware...)
 }
 
 // Patch registers a PATCH handler for the given path.
-func (r *Router) Patch(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) {
-	r.HandleFunc("PATCH", path, handler, middleware...)
+func (r *Router) Patch(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return r.HandleFunc("PATCH", path, handler, middleware...)
 }
 
 // Delete registers a DELETE handler for the given path.
-func (r *Router) Delete(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) {
-	r.HandleFunc("DELETE", path, handler, middleware...)
+func (r *Router) Delete(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return r.HandleFunc("DELETE", path, handler, middleware...)
 }
 
 // Options registers a OPTIONS handler for the given path.
-func (r *Router) Options(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) {
-	r.HandleFunc("OPTIONS", path, handler, middleware...)
-}
-
-type paramsContextWrapper struct {
-	context.Context
-	httprouter.Params
+func (r *Router) Options(path string, handler http.HandlerFunc, middleware ...MiddlewareFunc) *Route {
+	return r.HandleFunc("OPTIONS", path, handler, middleware...)
 }
 
-func wrap(handler http.Handler) httprouter.Handle {
+func wrap(handler http.Handler, route *Route) httprouter.Handle {
 	return func(rw http.ResponseWriter, req *http.Request, params httprouter.Params) {
 		ctx := GetContext(req)
 		ctx.Params = params
+		ctx.Route = route
+		defer ctx.finish()
 		handler.ServeHTTP(rw, req)
 	}
 }
@@ -167,7 +346,19 @@ func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if !ok {
 		return nil, nil, errors.New("the ResponseWriter doesn't support the Hijacker interface")
 	}
-	return hijacker.Hijack()
+	conn, buf, err := hijacker.Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// ResponseWriter supports it.
+func (w *ResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
 }
 
 func (s *ResponseWriter) Status() int {
@@ -0,0 +1,261 @@
+package engine_test
+
+import (
+	"bufio"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeMethod(rw http.ResponseWriter, req *http.Request) {
+	rw.Write([]byte(req.Method))
+}
+
+func TestRouterMethods(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/", writeMethod)
+	r.Post("/", writeMethod)
+	r.Put("/", writeMethod)
+	r.Patch("/", writeMethod)
+	r.Delete("/", writeMethod)
+	r.Options("/", writeMethod)
+	r.HandleFunc("PROPFIND", "/", writeMethod)
+
+	c := enginetest.New(t, r)
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "PROPFIND"} {
+		c.Request(method, "/").Do().AssertStatus(http.StatusOK).AssertBody(method)
+	}
+}
+
+func TestRouterHead(t *testing.T) {
+	r := engine.NewRouter()
+	r.Head("/", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Head", "yes")
+	})
+	enginetest.New(t, r).Head("/").Do().AssertStatus(http.StatusOK).AssertHeader("X-Head", "yes")
+}
+
+func TestRouterParams(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/users/:id/files/*path", func(rw http.ResponseWriter, req *http.Request) {
+		ctx := engine.GetContext(req)
+		engine.JSON(rw, engine.J{"id": ctx.Params.ByName("id"), "path": ctx.Params.ByName("path")}, http.StatusOK)
+	})
+	enginetest.New(t, r).Get("/users/42/files/a/b.txt").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/json; charset=utf-8").
+		AssertJSONPath("id", "42").
+		AssertJSONPath("path", "/a/b.txt")
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := engine.NewRouter()
+	enginetest.New(t, r).Get("/missing").Do().
+		AssertStatus(http.StatusNotFound).
+		AssertGolden("not_found")
+}
+
+func TestRouterSetNotFound(t *testing.T) {
+	r := engine.NewRouter()
+	r.SetNotFound(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	enginetest.New(t, r).Get("/missing").Do().AssertStatus(http.StatusTeapot)
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/", writeMethod)
+	c := enginetest.New(t, r)
+	r.Delete("/", writeMethod)
+	c.Post("/").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertHeader("Allow", "GET, HEAD, DELETE, OPTIONS").
+		AssertJSONPath("message", "Method Not Allowed")
+
+	r.Use(record("router"))
+	r.SetMethodNotAllowed(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	c.Post("/").Do().AssertStatus(http.StatusTeapot).AssertHeader("X-Order", "router")
+}
+
+func TestRouterOptions(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/users", writeMethod)
+	r.Post("/users", writeMethod)
+	c := enginetest.New(t, r)
+	c.Options("/users").Do().
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Allow", "GET, HEAD, POST, OPTIONS")
+	c.Options("/missing").Do().AssertStatus(http.StatusNotFound)
+
+	r.Use(engine.CORSAcceptAll)
+	c.Options("/users").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Access-Control-Allow-Origin", "*")
+}
+
+func TestRouterAutomaticHead(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, engine.J{"ok": true}, http.StatusOK)
+	})
+	r.Get("/sized", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "3")
+		rw.Write([]byte("abc"))
+	})
+	c := enginetest.New(t, r)
+	get := c.Get("/").Do()
+	c.Head("/").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/json; charset=utf-8").
+		AssertHeader("Content-Length", strconv.Itoa(get.Body.Len())).
+		AssertBody("")
+	c.Head("/sized").Do().AssertHeader("Content-Length", "3").AssertBody("")
+}
+
+// record returns middleware that appends name to the X-Order response header.
+func record(name string) engine.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Add("X-Order", name)
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func TestSubRouter(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(record("root"))
+	api := r.SubRouter("/api", record("api"))
+	api.Get("/users", writeMethod, record("route"))
+	r.Get("/health", writeMethod)
+
+	c := enginetest.New(t, r)
+	res := c.Get("/api/users").Do().AssertStatus(http.StatusOK)
+	if got, want := res.Header()["X-Order"], []string{"root", "api", "route"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("middleware order = %v, want %v", got, want)
+	}
+	res = c.Get("/health").Do().AssertStatus(http.StatusOK)
+	if got, want := res.Header()["X-Order"], []string{"root"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("middleware order = %v, want %v", got, want)
+	}
+	c.Get("/users").Do().AssertStatus(http.StatusNotFound)
+}
+
+func TestSubRouterDoesNotAffectParent(t *testing.T) {
+	r := engine.NewRouter()
+	api := r.SubRouter("/api")
+	api.Use(record("api"))
+	r.Get("/", writeMethod)
+	enginetest.New(t, r).Get("/").Do().AssertStatus(http.StatusOK).AssertHeader("X-Order", "")
+}
+
+func TestListMiddleware(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(engine.CORSAcceptAll)
+	mi := r.ListMiddleware()
+	if len(mi) != 2 || !strings.HasSuffix(mi[0], "MetadataMiddleware") || !strings.HasSuffix(mi[1], "CORSAcceptAll") {
+		t.Errorf("ListMiddleware() = %v", mi)
+	}
+}
+
+func TestUseHandler(t *testing.T) {
+	r := engine.NewRouter()
+	r.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Before", "yes")
+	}))
+	r.Get("/", writeMethod)
+	enginetest.New(t, r).Get("/").Do().AssertHeader("X-Before", "yes").AssertBody("GET")
+}
+
+type ctxKey string
+
+func TestContextValues(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			engine.GetContext(req).Set(ctxKey("user"), "matthew")
+			next.ServeHTTP(rw, req)
+		})
+	})
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(engine.GetContext(req).Value(ctxKey("user")).(string)))
+	})
+	enginetest.New(t, r).Get("/").Do().AssertBody("matthew")
+}
+
+func TestContextOnFinish(t *testing.T) {
+	var calls []string
+	r := engine.NewRouter()
+	r.Get("/", func(rw http.ResponseWriter, req *http.Request) {
+		ctx := engine.GetContext(req)
+		ctx.OnFinish(func() { calls = append(calls, "first") })
+		ctx.OnFinish(func() { calls = append(calls, "second") })
+		calls = append(calls, "handler")
+	})
+	enginetest.New(t, r).Get("/").Do()
+	if want := []string{"handler", "second", "first"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := engine.NewResponseWriter(rec)
+	if rw.Status() != http.StatusOK {
+		t.Errorf("initial Status() = %d, want 200", rw.Status())
+	}
+	rw.Header().Set("X-Test", "yes")
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write([]byte("hello "))
+	rw.Write([]byte("world"))
+	rw.Flush()
+
+	if rw.Status() != http.StatusCreated || rec.Code != http.StatusCreated {
+		t.Errorf("Status() = %d, recorded %d, want 201", rw.Status(), rec.Code)
+	}
+	if rw.Length() != 11 {
+		t.Errorf("Length() = %d, want 11", rw.Length())
+	}
+	if rec.Header().Get("X-Test") != "yes" {
+		t.Errorf("header not passed through")
+	}
+	if !rec.Flushed {
+		t.Errorf("Flush not passed through")
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (h hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	server, client := net.Pipe()
+	client.Close()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func TestResponseWriterHijack(t *testing.T) {
+	rw := engine.NewResponseWriter(httptest.NewRecorder())
+	if _, _, err := rw.Hijack(); err == nil {
+		t.Errorf("Hijack() on a ResponseWriter without Hijacker succeeded")
+	}
+
+	rw = engine.NewResponseWriter(hijackRecorder{httptest.NewRecorder()})
+	conn, _, err := rw.Hijack()
+	if err != nil {
+		t.Fatalf("Hijack() = %v", err)
+	}
+	conn.Close()
+	if rw.Status() != http.StatusSwitchingProtocols {
+		t.Errorf("Status() after Hijack = %d, want 101", rw.Status())
+	}
+}
@@ -0,0 +1,328 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a server-sent event.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// EventStream writes server-sent events to a client.
+type EventStream struct {
+	// LastEventID is the ID of the last event the client received before
+	// reconnecting, if any.
+	LastEventID string
+
+	mu      sync.Mutex
+	rw      http.ResponseWriter
+	flusher http.Flusher
+	done    <-chan struct{}
+}
+
+// SSE starts a text/event-stream response. It fails if rw can't be flushed.
+func SSE(rw http.ResponseWriter, req *http.Request) (*EventStream, error) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		return nil, errors.New("the ResponseWriter doesn't support the Flusher interface")
+	}
+
+	if md, ok := GetMetadata(GetContext(req)); ok {
+		md.Stream = true
+		md.Logger().WithField("path", md.Path).Info("event stream opened")
+	}
+
+	h := rw.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &EventStream{
+		LastEventID: req.Header.Get("Last-Event-ID"),
+		rw:          rw,
+		flusher:     flusher,
+		done:        req.Context().Done(),
+	}, nil
+}
+
+// Done is closed when the client disconnects.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// ErrInvalidEvent is returned by EventStream.Send for events whose ID or Event
+// contain a line break, which would let them inject fields.
+var ErrInvalidEvent = errors.New("event ID and name must not contain line breaks")
+
+// dataLines splits event data on the line breaks clients recognise.
+var dataLines = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// Send writes e to the client. Data is sent as one field per line, and events
+// with line breaks in their ID or Event are rejected with ErrInvalidEvent.
+func (s *EventStream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n") || strings.ContainsAny(e.Event, "\r\n") {
+		return ErrInvalidEvent
+	}
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry/time.Millisecond)
+	}
+	for _, line := range strings.Split(dataLines.Replace(e.Data), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Heartbeat writes a comment, which clients ignore, to keep the connection
+// open through proxies and to detect disconnected clients.
+func (s *EventStream) Heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s *EventStream) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return errors.New("the client disconnected")
+	default:
+	}
+	if _, err := s.rw.Write([]byte(data)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// BrokerConfig configures a Broker.
+type BrokerConfig struct {
+	// Replay is the number of recent events kept per topic for clients that
+	// resume with Last-Event-ID. Defaults to 100.
+	Replay int
+	// Buffer is the number of events queued for each subscriber. A subscriber
+	// that falls further behind is disconnected, and can resume from the replay
+	// buffer when it reconnects. Defaults to 16.
+	Buffer int
+	// Heartbeat is the interval between heartbeats. Defaults to 15 seconds.
+	Heartbeat time.Duration
+	// IdleTimeout is how long a topic without subscribers keeps its replay
+	// buffer after its last subscriber left or event was published, so
+	// clients can resume. Defaults to 5 minutes.
+	IdleTimeout time.Duration
+}
+
+// Broker fans out published events to the subscribers of a topic.
+type Broker struct {
+	config    BrokerConfig
+	mu        sync.Mutex
+	seq       uint64
+	topics    map[string]*topic
+	lastSweep time.Time
+}
+
+type topic struct {
+	subscribers map[*Subscription]struct{}
+	replay      []Event
+	// active is when the topic last had a subscriber leave or an event
+	// published.
+	active time.Time
+}
+
+// Subscription receives the events published to a topic.
+type Subscription struct {
+	// Events is closed when the subscription is cancelled or falls too far behind.
+	Events <-chan Event
+	events chan Event
+	broker *Broker
+	topic  string
+}
+
+// NewBroker creates a Broker.
+func NewBroker(config *BrokerConfig) *Broker {
+	b := &Broker{topics: make(map[string]*topic), lastSweep: time.Now()}
+	if config != nil {
+		b.config = *config
+	}
+	if b.config.Replay == 0 {
+		b.config.Replay = 100
+	}
+	if b.config.Buffer == 0 {
+		b.config.Buffer = 16
+	}
+	if b.config.Heartbeat == 0 {
+		b.config.Heartbeat = 15 * time.Second
+	}
+	if b.config.IdleTimeout == 0 {
+		b.config.IdleTimeout = 5 * time.Minute
+	}
+	return b
+}
+
+// Publish sends e to the subscribers of name, assigning it an ID if it doesn't
+// have one. Events published to topics without subscribers are kept for
+// replay too.
+func (b *Broker) Publish(name string, e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e.ID == "" {
+		b.seq++
+		e.ID = strconv.FormatUint(b.seq, 10)
+	}
+	now := time.Now()
+	b.sweep(now)
+	t := b.topic(name)
+	t.active = now
+	t.replay = append(t.replay, e)
+	if len(t.replay) > b.config.Replay {
+		t.replay = t.replay[len(t.replay)-b.config.Replay:]
+	}
+	for sub := range t.subscribers {
+		select {
+		case sub.events <- e:
+		default:
+			b.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscribe subscribes to name. If lastEventID is set, the events published
+// after it that are still in the replay buffer are returned, or the whole
+// buffer if the event is no longer held.
+func (b *Broker) Subscribe(name, lastEventID string) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(time.Now())
+	t := b.topic(name)
+	events := make(chan Event, b.config.Buffer)
+	sub := &Subscription{Events: events, events: events, broker: b, topic: name}
+	t.subscribers[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil
+	}
+	replay := t.replay
+	for i, e := range t.replay {
+		if e.ID == lastEventID {
+			replay = t.replay[i+1:]
+			break
+		}
+	}
+	return sub, append([]Event(nil), replay...)
+}
+
+// Cancel stops the subscription.
+func (s *Subscription) Cancel() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s)
+}
+
+// Subscribers returns the number of subscribers to name.
+func (b *Broker) Subscribers(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[name]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{subscribers: make(map[*Subscription]struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	t, ok := b.topics[sub.topic]
+	if !ok {
+		return
+	}
+	if _, ok := t.subscribers[sub]; !ok {
+		return
+	}
+	delete(t.subscribers, sub)
+	close(sub.events)
+	if len(t.subscribers) == 0 {
+		t.active = time.Now()
+	}
+}
+
+// sweep removes the topics that have been idle for IdleTimeout, at most once
+// per IdleTimeout.
+func (b *Broker) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < b.config.IdleTimeout {
+		return
+	}
+	b.lastSweep = now
+	for name, t := range b.topics {
+		if len(t.subscribers) == 0 && now.Sub(t.active) >= b.config.IdleTimeout {
+			delete(b.topics, name)
+		}
+	}
+}
+
+// Handler streams the events of the topic returned by topicFor to clients,
+// resuming from Last-Event-ID and sending heartbeats until the client
+// disconnects.
+func (b *Broker) Handler(topicFor func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		stream, err := SSE(rw, req)
+		if err != nil {
+			JSONError(rw, err, http.StatusInternalServerError)
+			return
+		}
+		sub, replay := b.Subscribe(topicFor(req), stream.LastEventID)
+		defer sub.Cancel()
+
+		for _, e := range replay {
+			if err := stream.Send(e); err != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(b.config.Heartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case e, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				if err := stream.Send(e); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if err := stream.Heartbeat(); err != nil {
+					return
+				}
+			case <-stream.Done():
+				return
+			}
+		}
+	})
+}
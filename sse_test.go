@@ -0,0 +1,174 @@
+package engine_test
+
+import (
+	"bufio"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStream(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/events", func(rw http.ResponseWriter, req *http.Request) {
+		stream, err := engine.SSE(rw, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stream.LastEventID != "7" {
+			t.Errorf("LastEventID = %q, want 7", stream.LastEventID)
+		}
+		stream.Send(engine.Event{ID: "8", Event: "update", Data: "one\r\ntwo\rthree\nfour", Retry: 2 * time.Second})
+		if err := stream.Send(engine.Event{ID: "9\nevent: admin", Data: "x"}); err != engine.ErrInvalidEvent {
+			t.Errorf("Send with a line break in the ID = %v, want ErrInvalidEvent", err)
+		}
+		if err := stream.Send(engine.Event{Event: "a\rb", Data: "x"}); err != engine.ErrInvalidEvent {
+			t.Errorf("Send with a line break in the name = %v, want ErrInvalidEvent", err)
+		}
+		stream.Heartbeat()
+	})
+	c := enginetest.New(t, r)
+
+	c.Get("/events").Header("Last-Event-ID", "7").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "text/event-stream").
+		AssertHeader("Cache-Control", "no-cache").
+		AssertBody("id: 8\nevent: update\nretry: 2000\ndata: one\ndata: two\ndata: three\ndata: four\n\n: heartbeat\n\n")
+}
+
+func TestBroker(t *testing.T) {
+	b := engine.NewBroker(&engine.BrokerConfig{Replay: 2, Buffer: 1})
+	b.Publish("news", engine.Event{Data: "unheard"})
+
+	sub, replay := b.Subscribe("news", "")
+	if len(replay) != 0 {
+		t.Errorf("replay without Last-Event-ID = %v", replay)
+	}
+	b.Publish("news", engine.Event{Data: "a"})
+	if e := <-sub.Events; e.ID != "2" || e.Data != "a" {
+		t.Errorf("event = %+v, want ID 2 with data a", e)
+	}
+	b.Publish("news", engine.Event{Data: "b"})
+	b.Publish("news", engine.Event{Data: "c"})
+	b.Publish("news", engine.Event{Data: "d"})
+
+	// The subscriber fell behind, so it's dropped after its buffered event.
+	if e := <-sub.Events; e.Data != "b" {
+		t.Errorf("event = %+v, want data b", e)
+	}
+	if _, ok := <-sub.Events; ok {
+		t.Error("slow subscriber not dropped")
+	}
+	if n := b.Subscribers("news"); n != 0 {
+		t.Errorf("Subscribers = %d, want 0", n)
+	}
+
+	b = engine.NewBroker(&engine.BrokerConfig{Replay: 2})
+	first, _ := b.Subscribe("news", "")
+	b.Publish("news", engine.Event{ID: "x", Data: "x"})
+	b.Publish("news", engine.Event{ID: "y", Data: "y"})
+	b.Publish("news", engine.Event{ID: "z", Data: "z"})
+	_, replay = b.Subscribe("news", "y")
+	if len(replay) != 1 || replay[0].ID != "z" {
+		t.Errorf("replay after y = %+v, want z", replay)
+	}
+	_, replay = b.Subscribe("news", "x")
+	if len(replay) != 2 || replay[0].ID != "y" {
+		t.Errorf("replay after evicted x = %+v, want y and z", replay)
+	}
+	first.Cancel()
+	first.Cancel()
+	if n := b.Subscribers("news"); n != 2 {
+		t.Errorf("Subscribers = %d, want 2", n)
+	}
+}
+
+func TestBrokerResume(t *testing.T) {
+	b := engine.NewBroker(nil)
+	sub, _ := b.Subscribe("user:1", "")
+	b.Publish("user:1", engine.Event{Data: "a"})
+	a := <-sub.Events
+	sub.Cancel()
+
+	// Events published while the only subscriber is away are replayed when
+	// it reconnects.
+	b.Publish("user:1", engine.Event{Data: "b"})
+	_, replay := b.Subscribe("user:1", a.ID)
+	if len(replay) != 1 || replay[0].Data != "b" {
+		t.Errorf("replay after %s = %+v, want b", a.ID, replay)
+	}
+}
+
+func TestBrokerIdleTopicsRemoved(t *testing.T) {
+	b := engine.NewBroker(&engine.BrokerConfig{IdleTimeout: 10 * time.Millisecond})
+	sub, _ := b.Subscribe("news", "")
+	b.Publish("news", engine.Event{ID: "1", Data: "a"})
+	sub.Cancel()
+	if _, ok := <-sub.Events; !ok {
+		t.Error("buffered event dropped on cancel")
+	}
+	_, replay := b.Subscribe("news", "0")
+	if len(replay) != 1 {
+		t.Errorf("replay before the topic went idle = %+v, want a", replay)
+	}
+
+	idle, _ := b.Subscribe("sports", "")
+	b.Publish("sports", engine.Event{Data: "goal"})
+	idle.Cancel()
+	time.Sleep(20 * time.Millisecond)
+	b.Publish("weather", engine.Event{Data: "sun"})
+	_, replay = b.Subscribe("sports", "0")
+	if len(replay) != 0 {
+		t.Errorf("replay of an idle topic = %+v, want none", replay)
+	}
+	_, replay = b.Subscribe("news", "0")
+	if len(replay) != 1 {
+		t.Errorf("replay of a subscribed topic = %+v, want a", replay)
+	}
+}
+
+func TestBrokerHandler(t *testing.T) {
+	b := engine.NewBroker(&engine.BrokerConfig{Heartbeat: 10 * time.Millisecond})
+	r := engine.NewRouter()
+	r.Handle("GET", "/events/:topic", b.Handler(func(req *http.Request) string {
+		return engine.GetContext(req).Params.ByName("topic")
+	}))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/events/news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return b.Subscribers("news") == 1 })
+	b.Publish("news", engine.Event{Event: "story", Data: "hello"})
+
+	lines := bufio.NewScanner(res.Body)
+	var got []string
+	for len(got) < 3 && lines.Scan() {
+		if line := lines.Text(); line != "" && !strings.HasPrefix(line, ":") {
+			got = append(got, line)
+		}
+	}
+	if want := "id: 1,event: story,data: hello"; strings.Join(got, ",") != want {
+		t.Errorf("stream = %q, want %q", strings.Join(got, ","), want)
+	}
+
+	res.Body.Close()
+	waitFor(t, func() bool { return b.Subscribers("news") == 0 })
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxETags bounds the number of files a fileServer remembers ETags for.
+const maxETags = 4096
+
+// StaticConfig configures how files are served by Router.StaticFS.
+type StaticConfig struct {
+	// Listing enables directory listings for directories without an index.html.
+	// Off by default.
+	Listing bool
+	// SPA serves the root index.html for any path that doesn't match a file,
+	// so client side routers can handle it.
+	SPA bool
+	// CacheControl is sent as the Cache-Control header with every file.
+	CacheControl string
+	// Precompressed serves name.gz in place of name when it exists and the
+	// client accepts gzip.
+	Precompressed bool
+}
+
+type fileServer struct {
+	root     http.FileSystem
+	config   StaticConfig
+	notFound func() http.Handler
+
+	mu    sync.Mutex
+	etags map[string]etagEntry
+}
+
+// etagEntry is the ETag of a version of a file.
+type etagEntry struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+func (s *fileServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.serveFile(rw, req, path.Clean("/"+GetContext(req).Params.ByName("filepath")))
+}
+
+func (s *fileServer) serveFile(rw http.ResponseWriter, req *http.Request, name string) {
+	f, info, err := s.open(name)
+	if err == nil && info.IsDir() {
+		f.Close()
+		// Relative links in the index or listing resolve against the
+		// directory only with a trailing slash.
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			target := req.URL.Path + "/"
+			if req.URL.RawQuery != "" {
+				target += "?" + req.URL.RawQuery
+			}
+			http.Redirect(rw, req, target, http.StatusMovedPermanently)
+			return
+		}
+		dir := name
+		name = path.Join(dir, "index.html")
+		f, info, err = s.open(name)
+		if err != nil && os.IsNotExist(err) && s.config.Listing {
+			s.listing(rw, req, dir)
+			return
+		}
+	}
+	if err != nil && os.IsNotExist(err) && s.config.SPA {
+		name = "/index.html"
+		f, info, err = s.open(name)
+	}
+	if err != nil {
+		s.error(rw, req, err)
+		return
+	}
+	defer f.Close()
+
+	if s.config.Precompressed {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(req) {
+			if gz, gzInfo, err := s.open(name + ".gz"); err == nil && !gzInfo.IsDir() {
+				defer gz.Close()
+				ctype := mime.TypeByExtension(path.Ext(name))
+				if ctype == "" {
+					ctype = "application/octet-stream"
+				}
+				rw.Header().Set("Content-Type", ctype)
+				rw.Header().Set("Content-Encoding", "gzip")
+				name, f, info = name+".gz", gz, gzInfo
+			}
+		}
+	}
+
+	etag, err := s.etag(name, f, info)
+	if err != nil {
+		s.error(rw, req, err)
+		return
+	}
+	rw.Header().Set("ETag", etag)
+	if s.config.CacheControl != "" {
+		rw.Header().Set("Cache-Control", s.config.CacheControl)
+	}
+	http.ServeContent(rw, req, info.Name(), info.ModTime(), f)
+}
+
+func (s *fileServer) open(name string) (http.File, os.FileInfo, error) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// etag returns a strong ETag for the file's contents, hashing each version of
+// the file only once. The ETags of up to maxETags files are remembered.
+func (s *fileServer) etag(name string, f http.File, info os.FileInfo) (string, error) {
+	s.mu.Lock()
+	e, ok := s.etags[name]
+	s.mu.Unlock()
+	if ok && e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+		return e.etag, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf("%q", hex.EncodeToString(h.Sum(nil)[:16]))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.etags == nil {
+		s.etags = make(map[string]etagEntry)
+	}
+	if _, ok := s.etags[name]; !ok && len(s.etags) >= maxETags {
+		for evict := range s.etags {
+			delete(s.etags, evict)
+			break
+		}
+	}
+	s.etags[name] = etagEntry{modTime: info.ModTime(), size: info.Size(), etag: etag}
+	return etag, nil
+}
+
+func (s *fileServer) listing(rw http.ResponseWriter, req *http.Request, name string) {
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	u.Path = name
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	r.URL = &u
+	http.FileServer(s.root).ServeHTTP(rw, r)
+}
+
+func (s *fileServer) error(rw http.ResponseWriter, req *http.Request, err error) {
+	switch {
+	case os.IsNotExist(err):
+		s.notFound().ServeHTTP(rw, req)
+	case os.IsPermission(err):
+		RenderError(rw, req, nil, http.StatusForbidden)
+	default:
+		RenderError(rw, req, nil, http.StatusInternalServerError)
+	}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip with
+// a quality above zero, explicitly or through *.
+func acceptsGzip(req *http.Request) bool {
+	gzip, star := -1.0, -1.0
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(enc, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				// Unparseable qualities are 0, refusing the coding.
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		switch strings.TrimSpace(coding) {
+		case "gzip":
+			gzip = q
+		case "*":
+			star = q
+		}
+	}
+	if gzip >= 0 {
+		return gzip > 0
+	}
+	return star > 0
+}
@@ -0,0 +1,93 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func staticFS() http.FileSystem {
+	modTime := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	return http.FS(fstest.MapFS{
+		"index.html":        {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+		"app.js":            {Data: []byte("console.log(1)"), ModTime: modTime},
+		"app.js.gz":         {Data: []byte("gzipped"), ModTime: modTime},
+		"docs/index.html":   {Data: []byte("<a href=\"intro.html\">intro</a>"), ModTime: modTime},
+		"files/a.txt":       {Data: []byte("a"), ModTime: modTime},
+		"files/nested/b.md": {Data: []byte("b"), ModTime: modTime},
+	})
+}
+
+func TestStaticFS(t *testing.T) {
+	r := engine.NewRouter()
+	r.StaticFS("/static", staticFS(), &engine.StaticConfig{CacheControl: "max-age=60", Precompressed: true})
+	c := enginetest.New(t, r)
+
+	res := c.Get("/static/app.js").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Cache-Control", "max-age=60").
+		AssertHeader("Content-Encoding", "").
+		AssertBody("console.log(1)")
+	etag := res.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	c.Get("/static/app.js").Header("If-None-Match", etag).Do().AssertStatus(http.StatusNotModified)
+
+	c.Get("/static/app.js").Header("Accept-Encoding", "gzip, br").Do().
+		AssertHeader("Content-Encoding", "gzip").
+		AssertHeader("Vary", "Accept-Encoding").
+		AssertBody("gzipped")
+	for _, accept := range []string{"gzip;q=0.0", "gzip; q=0", "*, gzip;q=0", "br"} {
+		c.Get("/static/app.js").Header("Accept-Encoding", accept).Do().
+			AssertHeader("Content-Encoding", "").
+			AssertBody("console.log(1)")
+	}
+	c.Get("/static/app.js").Header("Accept-Encoding", "*;q=0.5").Do().AssertHeader("Content-Encoding", "gzip")
+
+	c.Get("/static/docs?lang=en").Do().
+		AssertStatus(http.StatusMovedPermanently).
+		AssertHeader("Location", "/static/docs/?lang=en")
+	c.Get("/static/docs/").Do().AssertStatus(http.StatusOK).AssertBodyContains("intro.html")
+	c.Get("/static/files/").Do().AssertStatus(http.StatusNotFound)
+	c.Get("/static/missing.js").Do().AssertStatus(http.StatusNotFound)
+}
+
+func TestStaticFSListing(t *testing.T) {
+	r := engine.NewRouter()
+	r.StaticFS("/static", staticFS(), &engine.StaticConfig{Listing: true})
+	c := enginetest.New(t, r)
+	c.Get("/static/files").Do().AssertStatus(http.StatusMovedPermanently).AssertHeader("Location", "/static/files/")
+	c.Get("/static/files/").Do().
+		AssertStatus(http.StatusOK).
+		AssertBodyContains(`<a href="a.txt">a.txt</a>`).
+		AssertBodyContains(`<a href="nested/">nested/</a>`)
+}
+
+func TestStaticFSSPA(t *testing.T) {
+	r := engine.NewRouter()
+	r.StaticFS("/app", staticFS(), &engine.StaticConfig{SPA: true})
+	c := enginetest.New(t, r)
+	c.Get("/app/users/42").Do().AssertStatus(http.StatusOK).AssertBody("<h1>home</h1>")
+	c.Get("/app/app.js").Do().AssertBody("console.log(1)")
+}
+
+func TestStatic(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := engine.NewRouter()
+	r.Static("/files", dir)
+	c := enginetest.New(t, r)
+	c.Get("/files/sub/file.txt").Do().AssertStatus(http.StatusOK).AssertBody("hello")
+	c.Get("/files/sub/").Do().AssertStatus(http.StatusNotFound)
+}
@@ -0,0 +1,152 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// streamFlushInterval is the longest time streamed values are buffered
+// before being flushed to the client.
+const streamFlushInterval = 100 * time.Millisecond
+
+// ErrClientGone is returned by emit functions once the client has disconnected.
+var ErrClientGone = errors.New("engine: client disconnected")
+
+type jsonStreamer struct {
+	rw        http.ResponseWriter
+	req       *http.Request
+	buf       *bufio.Writer
+	enc       *json.Encoder
+	started   bool
+	count     int
+	lastFlush time.Time
+	open      string
+	sep       string
+	close     string
+}
+
+func (s *jsonStreamer) emit(v interface{}) error {
+	select {
+	case <-s.req.Context().Done():
+		return ErrClientGone
+	default:
+	}
+	if !s.started {
+		s.start()
+	}
+	if s.count > 0 {
+		s.buf.WriteString(s.sep)
+	}
+	s.count++
+	if err := s.enc.Encode(v); err != nil {
+		return err
+	}
+	if time.Since(s.lastFlush) >= streamFlushInterval {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *jsonStreamer) start() {
+	s.started = true
+	s.rw.WriteHeader(http.StatusOK)
+	s.buf.WriteString(s.open)
+	s.lastFlush = time.Now()
+}
+
+func (s *jsonStreamer) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := s.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	s.lastFlush = time.Now()
+	return nil
+}
+
+func (s *jsonStreamer) run(fn func(emit func(v interface{}) error) error) error {
+	if err := fn(s.emit); err != nil {
+		// Nothing has been sent yet, so the error can still be reported
+		// properly. Otherwise the response is left incomplete.
+		if !s.started {
+			JSONError(s.rw, err, http.StatusInternalServerError)
+			return err
+		}
+		s.flush()
+		return err
+	}
+	if !s.started {
+		s.start()
+	}
+	s.buf.WriteString(s.close)
+	return s.flush()
+}
+
+func newJSONStreamer(rw http.ResponseWriter, req *http.Request, contentType string) *jsonStreamer {
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	buf := bufio.NewWriter(rw)
+	return &jsonStreamer{rw: rw, req: req, buf: buf, enc: json.NewEncoder(buf)}
+}
+
+// StreamJSONLines streams each value passed to emit as newline delimited JSON
+// (application/x-ndjson). emit returns ErrClientGone once the client has
+// disconnected, which fn should return promptly. If fn fails before emitting
+// anything its error is rendered with JSONError.
+func StreamJSONLines(rw http.ResponseWriter, req *http.Request, fn func(emit func(v interface{}) error) error) error {
+	return newJSONStreamer(rw, req, "application/x-ndjson").run(fn)
+}
+
+// StreamJSONArray streams each value passed to emit as an element of a JSON
+// array, flushing periodically. If fn fails after emitting values the array
+// is left unterminated, so clients can't mistake it for a complete response.
+func StreamJSONArray(rw http.ResponseWriter, req *http.Request, fn func(emit func(v interface{}) error) error) error {
+	s := newJSONStreamer(rw, req, "application/json; charset=utf-8")
+	s.open, s.sep, s.close = "[", ",", "]\n"
+	return s.run(fn)
+}
+
+// JSONStream decodes a stream of JSON values, such as newline delimited JSON,
+// one at a time.
+//
+//	stream := engine.DecodeJSONStream(req)
+//	for stream.Next(&item) {
+//		...
+//	}
+//	if err := stream.Err(); err != nil {
+//		...
+//	}
+type JSONStream struct {
+	dec *json.Decoder
+	err error
+}
+
+// DecodeJSONStream returns a JSONStream reading from the request body.
+func DecodeJSONStream(req *http.Request) *JSONStream {
+	return &JSONStream{dec: json.NewDecoder(req.Body)}
+}
+
+// Next decodes the next value into v. It returns false at the end of the
+// stream or on error.
+func (s *JSONStream) Next(v interface{}) bool {
+	if s.err != nil {
+		return false
+	}
+	if err := s.dec.Decode(v); err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		return false
+	}
+	return true
+}
+
+// Err returns the first error encountered by Next.
+func (s *JSONStream) Err() error {
+	return s.err
+}
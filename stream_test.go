@@ -0,0 +1,113 @@
+package engine_test
+
+import (
+	"errors"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamJSON(t *testing.T) {
+	var streamErr error
+	emitN := func(n int, err error) func(emit func(v interface{}) error) error {
+		return func(emit func(v interface{}) error) error {
+			for i := 1; i <= n; i++ {
+				if err := emit(engine.J{"n": i}); err != nil {
+					return err
+				}
+			}
+			return err
+		}
+	}
+	r := engine.NewRouter()
+	r.Get("/lines", func(rw http.ResponseWriter, req *http.Request) {
+		streamErr = engine.StreamJSONLines(rw, req, emitN(2, nil))
+	})
+	r.Get("/array", func(rw http.ResponseWriter, req *http.Request) {
+		streamErr = engine.StreamJSONArray(rw, req, emitN(2, nil))
+	})
+	r.Get("/empty", func(rw http.ResponseWriter, req *http.Request) {
+		streamErr = engine.StreamJSONArray(rw, req, emitN(0, nil))
+	})
+	r.Get("/fail-early", func(rw http.ResponseWriter, req *http.Request) {
+		streamErr = engine.StreamJSONArray(rw, req, emitN(0, errors.New("boom")))
+	})
+	r.Get("/fail-late", func(rw http.ResponseWriter, req *http.Request) {
+		streamErr = engine.StreamJSONArray(rw, req, emitN(1, errors.New("boom")))
+	})
+	c := enginetest.New(t, r)
+
+	c.Get("/lines").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/x-ndjson").
+		AssertHeader("X-Content-Type-Options", "nosniff").
+		AssertBody("{\"n\":1}\n{\"n\":2}\n")
+	c.Get("/array").Do().
+		AssertHeader("Content-Type", "application/json; charset=utf-8").
+		AssertBody("[{\"n\":1}\n,{\"n\":2}\n]\n")
+	c.Get("/empty").Do().AssertStatus(http.StatusOK).AssertBody("[]\n")
+	if streamErr != nil {
+		t.Errorf("stream error = %v", streamErr)
+	}
+
+	c.Get("/fail-early").Do().AssertStatus(http.StatusInternalServerError).AssertJSONPath("message", "boom")
+	if streamErr == nil || streamErr.Error() != "boom" {
+		t.Errorf("stream error = %v, want boom", streamErr)
+	}
+	c.Get("/fail-late").Do().AssertStatus(http.StatusOK).AssertBody("[{\"n\":1}\n")
+	if streamErr == nil || streamErr.Error() != "boom" {
+		t.Errorf("stream error = %v, want boom", streamErr)
+	}
+}
+
+func TestStreamJSONClientGone(t *testing.T) {
+	var streamErr error
+	r := engine.NewRouter()
+	r.Get("/lines", func(rw http.ResponseWriter, req *http.Request) {
+		streamErr = engine.StreamJSONLines(rw, req, func(emit func(v interface{}) error) error {
+			for {
+				if err := emit(1); err != nil {
+					return err
+				}
+			}
+		})
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/lines", nil).WithContext(ctx)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if streamErr != engine.ErrClientGone {
+		t.Errorf("stream error = %v, want ErrClientGone", streamErr)
+	}
+}
+
+func TestDecodeJSONStream(t *testing.T) {
+	decode := func(body string) ([]int, error) {
+		var got []int
+		r := engine.NewRouter()
+		var err error
+		r.Post("/items", func(rw http.ResponseWriter, req *http.Request) {
+			stream := engine.DecodeJSONStream(req)
+			var item struct{ N int }
+			for stream.Next(&item) {
+				got = append(got, item.N)
+			}
+			err = stream.Err()
+		})
+		enginetest.New(t, r).Post("/items").Body("application/x-ndjson", strings.NewReader(body)).Do()
+		return got, err
+	}
+
+	got, err := decode("{\"n\":1}\n{\"n\":2}\n\n{\"n\":3}")
+	if err != nil || len(got) != 3 || got[2] != 3 {
+		t.Errorf("decode = %v, %v; want [1 2 3]", got, err)
+	}
+	got, err = decode("{\"n\":1}\n{\"n\":")
+	if err == nil || len(got) != 1 {
+		t.Errorf("decode truncated = %v, %v; want [1] and an error", got, err)
+	}
+}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimeoutConfig configures TimeoutMiddleware.
+type TimeoutConfig struct {
+	// Timeout is the time a handler has to complete before the request's
+	// context is cancelled.
+	Timeout time.Duration
+	// Handler renders the response sent when the deadline passes before the
+	// handler has written anything. If nil a JSON 503 is sent.
+	Handler http.Handler
+}
+
+// Timeout is middleware that cancels the request context after d and responds
+// with a 503 if the handler has not written a response by then.
+func Timeout(d time.Duration) MiddlewareFunc {
+	return TimeoutMiddleware(&TimeoutConfig{Timeout: d})
+}
+
+// TimeoutMiddleware returns middleware that enforces config.Timeout on each request.
+// Unlike http.TimeoutHandler the handler's writes go straight through until the
+// deadline; writes made after it are discarded and return http.ErrHandlerTimeout.
+func TimeoutMiddleware(config *TimeoutConfig) MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			ctx := GetContext(req)
+			tctx, cancel := context.WithTimeout(ctx.Context, config.Timeout)
+			defer cancel()
+			// The handler may outlive this call, so the cancelled context is
+			// left in place rather than restored.
+			ctx.Wrap(tctx)
+			req = req.WithContext(tctx)
+
+			tw := &timeoutWriter{rw: rw, header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			// OnFinish callbacks wait for the handler, which may still be
+			// using what they release after this returns.
+			release := ctx.hold()
+			go func() {
+				defer release()
+				defer func() {
+					if err := recover(); err != nil {
+						panicked <- err
+						return
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, req)
+			}()
+
+			select {
+			case err := <-panicked:
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+				panic(err)
+			case <-done:
+			case <-tctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				// The client went away before the deadline, so there's no one
+				// to respond to.
+				if tctx.Err() != context.DeadlineExceeded {
+					return
+				}
+				if md, ok := GetMetadata(ctx); ok {
+					md.TimedOut = true
+				}
+				if tw.wroteHeader {
+					return
+				}
+				if config.Handler != nil {
+					config.Handler.ServeHTTP(rw, req)
+					return
+				}
+				JSONError(rw, fmt.Errorf("Request timed out after %v", config.Timeout), http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// timeoutWriter passes writes through to rw until the deadline passes. The
+// handler gets its own header map so it never touches rw's after the timeout.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	rw          http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(data []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.rw.Write(data)
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeader(statusCode)
+}
+
+func (tw *timeoutWriter) writeHeader(statusCode int) {
+	dst := tw.rw.Header()
+	for k, v := range tw.header {
+		dst[k] = append([]string(nil), v...)
+	}
+	tw.wroteHeader = true
+	tw.rw.WriteHeader(statusCode)
+}
+
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	hijacker, ok := tw.rw.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter doesn't support the Hijacker interface")
+	}
+	return hijacker.Hijack()
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if flusher, ok := tw.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,63 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	r := engine.NewRouter()
+	r.Use(engine.Timeout(20 * time.Millisecond))
+	r.Get("/fast", writeMethod)
+	r.Get("/slow", func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+	r.Get("/partial", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Partial", "yes")
+		rw.WriteHeader(http.StatusAccepted)
+		<-req.Context().Done()
+		time.Sleep(5 * time.Millisecond)
+		_, err := rw.Write([]byte("late"))
+		lateWrite <- err
+	})
+	r.Get("/panic", func(rw http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+	c := enginetest.New(t, r)
+
+	c.Get("/fast").Do().AssertStatus(http.StatusOK).AssertBody("GET")
+	res := c.Get("/slow").Do().
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertJSONPath("message", "Request timed out after 20ms")
+	if md, ok := engine.GetMetadata(engine.GetContext(res.Request)); !ok || !md.TimedOut {
+		t.Error("metadata not marked as timed out")
+	}
+	c.Get("/partial").Do().AssertStatus(http.StatusAccepted).AssertHeader("X-Partial", "yes").AssertBody("")
+	select {
+	case err := <-lateWrite:
+		if err != http.ErrHandlerTimeout {
+			t.Errorf("write after timeout = %v, want http.ErrHandlerTimeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler didn't return after the timeout")
+	}
+	c.Get("/panic").Do().AssertStatus(http.StatusInternalServerError)
+}
+
+func TestTimeoutHandler(t *testing.T) {
+	r := engine.NewRouter()
+	r.Use(engine.TimeoutMiddleware(&engine.TimeoutConfig{
+		Timeout: 10 * time.Millisecond,
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusGatewayTimeout)
+		}),
+	}))
+	r.Get("/slow", func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	})
+	enginetest.New(t, r).Get("/slow").Do().AssertStatus(http.StatusGatewayTimeout)
+}
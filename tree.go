@@ -0,0 +1,474 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// treeMux routes requests by method and path. Paths are made of segments
+// separated by slashes, each one of:
+//
+//	users          a static segment
+//	:id, {id}      a param matching any non-empty segment
+//	{id:[0-9]+}    a param matching a regular expression
+//	:name.png      a param with static text around it, also {name}.png or
+//	               img-{name}
+//	:id?, {id?}    an optional param, allowed in trailing segments
+//	*path          a catch-all param matching the rest of the path, including
+//	               slashes, allowed as the last segment
+//
+// Segments at the same level don't conflict. Static segments take precedence
+// over params, params with more static text over those with less, params with
+// a regular expression over those without, and catch-alls come last. A request
+// that doesn't match below a segment backtracks to try the next one.
+type treeMux struct {
+	trees     map[string]*node
+	maxParams int
+
+	// NotFound serves requests that match no route.
+	NotFound http.Handler
+	// RedirectTrailingSlash redirects requests with or without a trailing
+	// slash to the route that has the other.
+	RedirectTrailingSlash bool
+	// RedirectFixedPath redirects requests to the cleaned path of a route
+	// matching it ignoring case.
+	RedirectFixedPath bool
+	// CaseInsensitive serves requests with the route matching their path
+	// ignoring case.
+	CaseInsensitive bool
+	// PanicHandler, if set, recovers panics from handles.
+	PanicHandler func(http.ResponseWriter, *http.Request, interface{})
+}
+
+// node is a segment in a method's tree.
+type node struct {
+	// statics are searched in order, or through staticIndex once there are
+	// enough of them for a map to be quicker.
+	statics     []*node
+	staticIndex map[string]*node
+	params      []*node
+	catchAll    *node
+
+	// seg is how the segment was written, such as users or {id:[0-9]+}, and
+	// key is its segment's key.
+	seg    string
+	key    string
+	name   string
+	prefix string
+	suffix string
+	re     *regexp.Regexp
+
+	handle httprouter.Handle
+	path   string
+}
+
+func newTreeMux() *treeMux {
+	return &treeMux{trees: make(map[string]*node), RedirectTrailingSlash: true, RedirectFixedPath: true}
+}
+
+// segment is a parsed path segment.
+type segment struct {
+	static   string
+	param    bool
+	catchAll bool
+	optional bool
+	name     string
+	prefix   string
+	suffix   string
+	pattern  string
+}
+
+// key identifies the requests a segment matches, ignoring its param name.
+func (s segment) key() string {
+	switch {
+	case s.catchAll:
+		return "*"
+	case s.param:
+		return s.prefix + "{:" + s.pattern + "}" + s.suffix
+	}
+	return s.static
+}
+
+func parseSegment(s string) (segment, error) {
+	switch {
+	case strings.HasPrefix(s, "*"):
+		if len(s) == 1 {
+			return segment{}, fmt.Errorf("catch-all %q needs a name", s)
+		}
+		return segment{catchAll: true, name: s[1:]}, nil
+	case strings.HasPrefix(s, ":"):
+		end := 1
+		for end < len(s) && isParamNameByte(s[end]) {
+			end++
+		}
+		seg := segment{param: true, name: s[1:end], suffix: s[end:]}
+		if strings.HasPrefix(seg.suffix, "?") {
+			seg.optional, seg.suffix = true, seg.suffix[1:]
+		}
+		if seg.name == "" {
+			return segment{}, fmt.Errorf("param %q needs a name", s)
+		}
+		return seg, nil
+	}
+
+	open := strings.IndexByte(s, '{')
+	if open < 0 {
+		return segment{static: s}, nil
+	}
+	depth, end := 0, -1
+	for i := open; i < len(s) && end < 0; i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return segment{}, fmt.Errorf("param %q is missing a closing brace", s)
+	}
+	seg := segment{param: true, prefix: s[:open], suffix: s[end+1:], name: s[open+1 : end]}
+	if i := strings.IndexByte(seg.name, ':'); i >= 0 {
+		seg.name, seg.pattern = seg.name[:i], seg.name[i+1:]
+	}
+	if strings.HasSuffix(seg.name, "?") {
+		seg.optional, seg.name = true, strings.TrimSuffix(seg.name, "?")
+	}
+	if seg.name == "" {
+		return segment{}, fmt.Errorf("param %q needs a name", s)
+	}
+	return seg, nil
+}
+
+func isParamNameByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// parsePath splits path into segments, checking optional and catch-all
+// segments are where they're allowed.
+func parsePath(path string) ([]segment, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must begin with /")
+	}
+	parts := strings.Split(path[1:], "/")
+	segments := make([]segment, len(parts))
+	for i, part := range parts {
+		seg, err := parseSegment(part)
+		if err != nil {
+			return nil, err
+		}
+		if seg.catchAll && i != len(parts)-1 {
+			return nil, fmt.Errorf("catch-all %q must be the last segment", part)
+		}
+		if i > 0 && segments[i-1].optional && !seg.optional {
+			return nil, fmt.Errorf("only trailing segments can be optional")
+		}
+		segments[i] = seg
+	}
+	return segments, nil
+}
+
+// Handle registers handle for method and path. Paths with optional segments
+// are registered with and without each of them.
+func (m *treeMux) Handle(method, path string, handle httprouter.Handle) {
+	segments, err := parsePath(path)
+	if err != nil {
+		panic(fmt.Sprintf("engine: %s %s: %v", method, path, err))
+	}
+	root := m.trees[method]
+	if root == nil {
+		root = &node{}
+		m.trees[method] = root
+	}
+	params := 0
+	for _, seg := range segments {
+		if seg.param || seg.catchAll {
+			params++
+		}
+	}
+	if params > m.maxParams {
+		m.maxParams = params
+	}
+	variant := segments
+	for {
+		if err := root.add(variant, path, handle); err != nil {
+			panic(fmt.Sprintf("engine: %s %s: %v", method, path, err))
+		}
+		if !variant[len(variant)-1].optional {
+			return
+		}
+		variant = variant[:len(variant)-1]
+		if len(variant) == 0 {
+			// "/:id?" without its segment is "/".
+			variant = []segment{{}}
+		}
+	}
+}
+
+func (n *node) add(segments []segment, path string, handle httprouter.Handle) error {
+	for _, seg := range segments {
+		next, err := n.child(seg)
+		if err != nil {
+			return err
+		}
+		n = next
+	}
+	if n.handle != nil {
+		return fmt.Errorf("conflicts with %s", n.path)
+	}
+	n.handle, n.path = handle, path
+	return nil
+}
+
+// child returns the child node for seg, adding it if needed.
+func (n *node) child(seg segment) (*node, error) {
+	switch {
+	case seg.catchAll:
+		if n.catchAll == nil {
+			n.catchAll = &node{seg: "*" + seg.name, name: seg.name}
+		} else if n.catchAll.name != seg.name {
+			return nil, fmt.Errorf("catch-all *%s conflicts with %s", seg.name, n.catchAll.seg)
+		}
+		return n.catchAll, nil
+	case seg.param:
+		for _, p := range n.params {
+			if p.key != seg.key() {
+				continue
+			}
+			if p.name != seg.name {
+				return nil, fmt.Errorf("param %s conflicts with %s", seg.name, p.seg)
+			}
+			return p, nil
+		}
+		p := &node{seg: seg.prefix + "{" + seg.name + "}" + seg.suffix, key: seg.key(), name: seg.name, prefix: seg.prefix, suffix: seg.suffix}
+		if seg.pattern != "" {
+			re, err := regexp.Compile("^(?:" + seg.pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("param %s: %v", seg.name, err)
+			}
+			p.re = re
+			p.seg = seg.prefix + "{" + seg.name + ":" + seg.pattern + "}" + seg.suffix
+		}
+		n.params = append(n.params, p)
+		sort.SliceStable(n.params, func(i, j int) bool {
+			a, b := n.params[i], n.params[j]
+			if la, lb := len(a.prefix)+len(a.suffix), len(b.prefix)+len(b.suffix); la != lb {
+				return la > lb
+			}
+			return a.re != nil && b.re == nil
+		})
+		return p, nil
+	}
+	if child := n.staticChild(seg.static); child != nil {
+		return child, nil
+	}
+	child := &node{seg: seg.static}
+	n.statics = append(n.statics, child)
+	if len(n.statics) > maxStaticScan {
+		n.staticIndex = make(map[string]*node, len(n.statics))
+		for _, c := range n.statics {
+			n.staticIndex[c.seg] = c
+		}
+	}
+	return child, nil
+}
+
+// matchParam returns the param's value in seg, if it matches.
+func (p *node) matchParam(seg string, fold bool) (string, bool) {
+	if len(seg) <= len(p.prefix)+len(p.suffix) {
+		return "", false
+	}
+	prefix, suffix := seg[:len(p.prefix)], seg[len(seg)-len(p.suffix):]
+	if fold {
+		if !strings.EqualFold(prefix, p.prefix) || !strings.EqualFold(suffix, p.suffix) {
+			return "", false
+		}
+	} else if prefix != p.prefix || suffix != p.suffix {
+		return "", false
+	}
+	value := seg[len(p.prefix) : len(seg)-len(p.suffix)]
+	if p.re != nil && !p.re.MatchString(value) {
+		return "", false
+	}
+	return value, true
+}
+
+// maxStaticScan is the most static children a node searches in order.
+const maxStaticScan = 8
+
+func (n *node) staticChild(seg string) *node {
+	if n.staticIndex != nil {
+		return n.staticIndex[seg]
+	}
+	for _, child := range n.statics {
+		if child.seg == seg {
+			return child
+		}
+	}
+	return nil
+}
+
+// lookupState is what a lookup collects as it descends the tree. With fold,
+// static text is matched ignoring case and the path as registered is
+// collected in fixed.
+type lookupState struct {
+	params    httprouter.Params
+	maxParams int
+	fold      bool
+	fixed     []string
+}
+
+func (st *lookupState) addParam(key, value string) {
+	if st.params == nil {
+		st.params = make(httprouter.Params, 0, st.maxParams)
+	}
+	st.params = append(st.params, httprouter.Param{Key: key, Value: value})
+}
+
+// lookup finds the node matching path from start, the index after the slash
+// before this level's segment.
+func (n *node) lookup(path string, start int, st *lookupState) *node {
+	end := strings.IndexByte(path[start:], '/')
+	last := end < 0
+	if last {
+		end = len(path)
+	} else {
+		end += start
+	}
+	seg := path[start:end]
+
+	if child := n.staticChild(seg); child != nil {
+		if match := child.descend(path, end, last, st, seg); match != nil {
+			return match
+		}
+	}
+	if st.fold {
+		for _, child := range n.statics {
+			if child.seg != seg && strings.EqualFold(child.seg, seg) {
+				if match := child.descend(path, end, last, st, child.seg); match != nil {
+					return match
+				}
+			}
+		}
+	}
+	for _, p := range n.params {
+		value, ok := p.matchParam(seg, st.fold)
+		if !ok {
+			continue
+		}
+		st.addParam(p.name, value)
+		text := seg
+		if st.fold {
+			text = p.prefix + value + p.suffix
+		}
+		if match := p.descend(path, end, last, st, text); match != nil {
+			return match
+		}
+		st.params = st.params[:len(st.params)-1]
+	}
+	if n.catchAll != nil {
+		st.addParam(n.catchAll.name, path[start-1:])
+		if st.fold {
+			st.fixed = append(st.fixed, path[start:])
+		}
+		return n.catchAll
+	}
+	return nil
+}
+
+// descend continues a lookup below n, whose segment ends at end.
+func (n *node) descend(path string, end int, last bool, st *lookupState, text string) *node {
+	if st.fold {
+		st.fixed = append(st.fixed, text)
+	}
+	var match *node
+	if !last {
+		match = n.lookup(path, end+1, st)
+	} else if n.handle != nil {
+		match = n
+	}
+	if match == nil && st.fold {
+		st.fixed = st.fixed[:len(st.fixed)-1]
+	}
+	return match
+}
+
+// Lookup returns the handle and params for method and path, matching it
+// ignoring case with CaseInsensitive.
+func (m *treeMux) Lookup(method, path string) (httprouter.Handle, httprouter.Params, bool) {
+	handle, params, _ := m.find(method, path, m.CaseInsensitive)
+	return handle, params, handle != nil
+}
+
+// find returns the handle and params for method and path. If fold is set and
+// there's no exact match, static text is matched ignoring case, and the path
+// of the match with the route's case is returned too.
+func (m *treeMux) find(method, path string, fold bool) (httprouter.Handle, httprouter.Params, string) {
+	root := m.trees[method]
+	if root == nil || !strings.HasPrefix(path, "/") {
+		return nil, nil, ""
+	}
+	st := lookupState{maxParams: m.maxParams}
+	if match := root.lookup(path, 1, &st); match != nil {
+		return match.handle, st.params, path
+	}
+	if !fold {
+		return nil, nil, ""
+	}
+	st = lookupState{maxParams: m.maxParams, fold: true}
+	if match := root.lookup(path, 1, &st); match != nil {
+		return match.handle, st.params, "/" + strings.Join(st.fixed, "/")
+	}
+	return nil, nil, ""
+}
+
+func (m *treeMux) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if m.PanicHandler != nil {
+		defer func() {
+			if err := recover(); err != nil {
+				m.PanicHandler(rw, req, err)
+			}
+		}()
+	}
+
+	path := req.URL.Path
+	if handle, params, _ := m.Lookup(req.Method, path); handle != nil {
+		handle(rw, req, params)
+		return
+	}
+	if req.Method != "CONNECT" && path != "/" {
+		code := http.StatusMovedPermanently
+		if req.Method != "GET" {
+			code = http.StatusTemporaryRedirect
+		}
+		if m.RedirectTrailingSlash {
+			other := path + "/"
+			if strings.HasSuffix(path, "/") {
+				other = path[:len(path)-1]
+			}
+			if handle, _, _ := m.Lookup(req.Method, other); handle != nil {
+				req.URL.Path = other
+				http.Redirect(rw, req, req.URL.String(), code)
+				return
+			}
+		}
+		if m.RedirectFixedPath {
+			if handle, _, fixed := m.find(req.Method, httprouter.CleanPath(path), true); handle != nil {
+				req.URL.Path = fixed
+				http.Redirect(rw, req, req.URL.String(), code)
+				return
+			}
+		}
+	}
+	if m.NotFound != nil {
+		m.NotFound.ServeHTTP(rw, req)
+	} else {
+		http.NotFound(rw, req)
+	}
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var benchRoutes = []string{
+	"/",
+	"/users",
+	"/users/:id",
+	"/users/:id/posts",
+	"/users/:id/posts/:post",
+	"/users/:id/followers",
+	"/repos/:owner/:repo",
+	"/repos/:owner/:repo/issues",
+	"/repos/:owner/:repo/issues/:number",
+	"/repos/:owner/:repo/pulls/:number/comments",
+	"/search/repositories",
+	"/search/users",
+	"/static/*filepath",
+}
+
+var benchRequests = []string{
+	"/",
+	"/users/42",
+	"/users/42/posts/7",
+	"/repos/mnbbrown/engine/issues/12",
+	"/repos/mnbbrown/engine/pulls/3/comments",
+	"/search/users",
+	"/static/css/site.css",
+}
+
+func benchmarkHandler(b *testing.B, h http.Handler, paths []string) {
+	reqs := make([]*http.Request, len(paths))
+	for i, path := range paths {
+		reqs[i] = httptest.NewRequest("GET", path, nil)
+	}
+	rw := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, req := range reqs {
+			h.ServeHTTP(rw, req)
+		}
+	}
+}
+
+func nopHandle(http.ResponseWriter, *http.Request, httprouter.Params) {}
+
+func BenchmarkTreeMux(b *testing.B) {
+	m := newTreeMux()
+	for _, path := range benchRoutes {
+		m.Handle("GET", path, nopHandle)
+	}
+	benchmarkHandler(b, m, benchRequests)
+}
+
+func BenchmarkHTTPRouter(b *testing.B) {
+	m := httprouter.New()
+	for _, path := range benchRoutes {
+		m.Handle("GET", path, nopHandle)
+	}
+	benchmarkHandler(b, m, benchRequests)
+}
+
+func BenchmarkTreeMuxPrecedence(b *testing.B) {
+	m := newTreeMux()
+	for _, path := range []string{"/users/new", "/users/:id", "/orders/{id:[0-9]+}", "/orders/{slug}", "/img/:name.png", "/files/meta", "/files/*path"} {
+		m.Handle("GET", path, nopHandle)
+	}
+	benchmarkHandler(b, m, []string{"/users/new", "/users/42", "/orders/17", "/orders/latest", "/img/cat.png", "/files/a/b"})
+}
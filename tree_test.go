@@ -0,0 +1,84 @@
+package engine_test
+
+import (
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// route responds with its name and params, like "id:42 new".
+func route(name string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		var parts []string
+		for _, p := range engine.GetContext(req).Params {
+			parts = append(parts, p.Key+":"+p.Value)
+		}
+		rw.Write([]byte(strings.Join(append(parts, name), " ")))
+	}
+}
+
+func TestTreePrecedence(t *testing.T) {
+	r := engine.NewRouter()
+	r.Get("/users/new", route("new"))
+	r.Get("/users/:id", route("user"))
+	r.Get("/users/:id/posts", route("posts"))
+	r.Get("/users/new/drafts", route("drafts"))
+	r.Get("/orders/{id:[0-9]+}", route("order"))
+	r.Get("/orders/{slug}", route("slug"))
+	r.Get("/img/:name.png", route("png"))
+	r.Get("/img/:name", route("img"))
+	r.Get("/img/thumb-{name}.png", route("thumb"))
+	r.Get("/files/meta", route("meta"))
+	r.Get("/files/*path", route("files"))
+	r.Get("/posts/:year/:month?", route("archive"))
+
+	c := enginetest.New(t, r)
+	for path, want := range map[string]string{
+		"/users/new":         "new",
+		"/users/42":          "id:42 user",
+		"/users/new/posts":   "id:new posts",
+		"/users/new/drafts":  "drafts",
+		"/orders/17":         "id:17 order",
+		"/orders/latest":     "slug:latest slug",
+		"/img/cat.png":       "name:cat png",
+		"/img/cat.jpg":       "name:cat.jpg img",
+		"/img/thumb-cat.png": "name:cat thumb",
+		"/img/.png":          "name:.png img",
+		"/files/meta":        "meta",
+		"/files/meta/a.txt":  "path:/meta/a.txt files",
+		"/files/":            "path:/ files",
+		"/posts/2026":        "year:2026 archive",
+		"/posts/2026/10":     "year:2026 month:10 archive",
+	} {
+		c.Get(path).Do().AssertStatus(http.StatusOK).AssertBody(want)
+	}
+	c.Get("/posts/2026/10/19").Do().AssertStatus(http.StatusNotFound)
+	c.Get("/users/42/").Do().AssertStatus(http.StatusMovedPermanently).AssertHeader("Location", "/users/42")
+	c.Get("/USERS/new").Do().AssertStatus(http.StatusMovedPermanently).AssertHeader("Location", "/users/new")
+}
+
+func TestTreeRegistrationErrors(t *testing.T) {
+	for _, paths := range [][]string{
+		{"/users/:id", "/users/:name"},
+		{"/users/:id", "/users/{id}"},
+		{"/files/*path", "/files/*name"},
+		{"/files/*path/meta"},
+		{"/posts/:year?/:month"},
+		{"/orders/{id:[0-9+}"},
+		{"users"},
+	} {
+		func() {
+			defer func() {
+				if msg, _ := recover().(string); !strings.HasPrefix(msg, "engine: GET "+paths[len(paths)-1]) {
+					t.Errorf("registering %q panicked with %q", paths, msg)
+				}
+			}()
+			r := engine.NewRouter()
+			for _, p := range paths {
+				r.Get(p, nop)
+			}
+		}()
+	}
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"reflect"
+)
+
+// StatusError is an error rendered with its status code by typed handlers.
+// JSONError adds its Extensions to the body, alongside status_code and message.
+type StatusError struct {
+	Code       int
+	Err        error
+	Extensions J
+}
+
+// Errorf returns a StatusError with a formatted message.
+func Errorf(code int, format string, args ...interface{}) error {
+	return &StatusError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *StatusError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorStatus returns the status code err is rendered with: a StatusError's
+// code, 422 for ValidationErrors or otherwise 500.
+func ErrorStatus(err error) int {
+	var se *StatusError
+	var ve ValidationErrors
+	switch {
+	case errors.As(err, &se):
+		return se.Code
+	case errors.As(err, &ve):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// statusCoder is implemented by typed handlers' responses that aren't 200 OK.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// Typed returns a handler calling handler with the request bound to Req, as
+// with Bind, and rendering the Resp it returns with Render. Responses with a
+// StatusCode() int method are rendered with that status, and 204s without a
+// body. Errors, including binding's, are rendered with RenderError with the
+// status from ErrorStatus. Other errors than StatusErrors and ValidationErrors
+// are logged rather than shown.
+//
+//	r.Handle("POST", "/users", engine.Typed(func(ctx *engine.Context, in CreateUser) (*User, error) {
+//		...
+//	}))
+//
+// Routes of typed handlers are documented with Req and Resp. Route.Request
+// replaces the documented request and Route.Response adds responses.
+func Typed[Req, Resp any](handler func(ctx *Context, in Req) (Resp, error)) http.Handler {
+	return typedHandler[Req, Resp](handler)
+}
+
+type typedHandler[Req, Resp any] func(*Context, Req) (Resp, error)
+
+func (h typedHandler[Req, Resp]) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var in Req
+	if err := bindTyped(req, &in); err != nil {
+		renderTypedError(rw, req, err)
+		return
+	}
+	out, err := h(GetContext(req), in)
+	if err != nil {
+		renderTypedError(rw, req, err)
+		return
+	}
+	code := http.StatusOK
+	if sc, ok := interface{}(out).(statusCoder); ok {
+		code = sc.StatusCode()
+	}
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		rw.WriteHeader(code)
+		return
+	}
+	Render(rw, req, out, code)
+}
+
+// describe documents the route with the handler's types.
+func (h typedHandler[Req, Resp]) describe(rt *Route) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.request == nil {
+		rt.request = reflect.TypeOf((*Req)(nil)).Elem()
+	}
+	if rt.responses == nil {
+		t := reflect.TypeOf((*Resp)(nil)).Elem()
+		code := http.StatusOK
+		zero := reflect.Zero(t)
+		if t.Kind() == reflect.Ptr {
+			zero = reflect.New(t.Elem())
+		}
+		if sc, ok := zero.Interface().(statusCoder); ok {
+			code = sc.StatusCode()
+		}
+		if code == http.StatusNoContent || code == http.StatusNotModified {
+			t = nil
+		}
+		rt.responses = map[int]reflect.Type{code: t}
+	}
+}
+
+// bindTyped binds a typed handler's request. Structs and pointers to them are
+// bound with Bind, and other types from the JSON body.
+func bindTyped(req *http.Request, dst interface{}) error {
+	rv := reflect.ValueOf(dst).Elem()
+	switch {
+	case rv.Kind() == reflect.Struct:
+		return Bind(req, dst)
+	case rv.Kind() == reflect.Ptr && rv.Type().Elem().Kind() == reflect.Struct:
+		rv.Set(reflect.New(rv.Type().Elem()))
+		return Bind(req, rv.Interface())
+	case hasBody(req):
+		return BindJSON(req, dst)
+	}
+	return nil
+}
+
+func renderTypedError(rw http.ResponseWriter, req *http.Request, err error) {
+	code := ErrorStatus(err)
+	var se *StatusError
+	var ve ValidationErrors
+	switch {
+	case errors.As(err, &se):
+		err = se
+	case errors.As(err, &ve):
+		err = ve
+	default:
+		if md, ok := GetMetadata(GetContext(req)); ok {
+			md.Logger().Error(err)
+		} else {
+			log.Error(err)
+		}
+		err = nil
+	}
+	RenderError(rw, req, err, code)
+}
@@ -0,0 +1,90 @@
+package engine_test
+
+import (
+	"errors"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"testing"
+)
+
+type CreatePostRequest struct {
+	UserID int    `param:"user_id"`
+	Draft  bool   `query:"draft"`
+	Tenant string `header:"X-Tenant" validate:"required"`
+	Title  string `json:"title" validate:"required"`
+}
+
+type Post struct {
+	ID     int    `json:"id" xml:"id,attr"`
+	UserID int    `json:"user_id" xml:"user_id"`
+	Title  string `json:"title" xml:"title"`
+	Draft  bool   `json:"draft" xml:"draft"`
+}
+
+type CreatedPost struct {
+	Post
+}
+
+func (CreatedPost) StatusCode() int {
+	return http.StatusCreated
+}
+
+func TestTyped(t *testing.T) {
+	r := engine.NewRouter()
+	r.Handle("POST", "/users/:user_id/posts", engine.Typed(func(ctx *engine.Context, in CreatePostRequest) (CreatedPost, error) {
+		switch in.Title {
+		case "taken":
+			return CreatedPost{}, engine.Errorf(http.StatusConflict, "title %q is taken", in.Title)
+		case "broken":
+			return CreatedPost{}, errors.New("database password is hunter2")
+		}
+		return CreatedPost{Post{ID: 1, UserID: in.UserID, Title: in.Title, Draft: in.Draft}}, nil
+	}))
+	r.Handle("GET", "/posts/:id", engine.Typed(func(ctx *engine.Context, in *struct {
+		ID int `param:"id"`
+	}) (*Post, error) {
+		return &Post{ID: in.ID, Title: "Hello"}, nil
+	}))
+
+	c := enginetest.New(t, r)
+	c.Post("/users/7/posts").Query("draft", "true").Header("X-Tenant", "acme").JSON(engine.J{"title": "Hello"}).Do().
+		AssertStatus(http.StatusCreated).
+		AssertHeader("Content-Type", "application/json; charset=utf-8").
+		AssertJSONPath("user_id", 7).
+		AssertJSONPath("draft", true).
+		AssertJSONPath("title", "Hello")
+	c.Post("/users/7/posts").JSON(engine.J{}).Do().
+		AssertStatus(http.StatusUnprocessableEntity).
+		AssertJSONPath("errors.0.field", "X-Tenant").
+		AssertJSONPath("errors.1.field", "title")
+	c.Post("/users/7/posts").Header("X-Tenant", "acme").JSON(engine.J{"title": "taken"}).Do().
+		AssertStatus(http.StatusConflict).
+		AssertJSONPath("message", `title "taken" is taken`)
+	c.Post("/users/7/posts").Header("X-Tenant", "acme").JSON(engine.J{"title": "broken"}).Do().
+		AssertStatus(http.StatusInternalServerError).
+		AssertJSONPath("message", "Internal Server Error")
+
+	c.Get("/posts/3").Header("Accept", "application/xml").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/xml; charset=utf-8").
+		AssertBodyContains(`<Post id="3"><user_id>0</user_id><title>Hello</title>`)
+	c.Get("/posts/3").Header("Accept", "text/html, application/*;q=0.5").Do().
+		AssertHeader("Content-Type", "application/json; charset=utf-8").
+		AssertJSONPath("id", 3)
+	c.Get("/posts/3").Header("Accept", "text/html").Do().
+		AssertStatus(http.StatusNotAcceptable)
+
+	doc := r.OpenAPI(&engine.OpenAPIConfig{Title: "Posts", Version: "1"})
+	paths := doc["paths"].(engine.J)
+	op := paths["/users/{user_id}/posts"].(engine.J)["post"].(engine.J)
+	if _, ok := op["requestBody"]; !ok {
+		t.Errorf("POST /users/{user_id}/posts has no request body: %v", op)
+	}
+	if _, ok := op["responses"].(engine.J)["201"]; !ok {
+		t.Errorf("POST /users/{user_id}/posts responses = %v, want a 201", op["responses"])
+	}
+	if _, ok := paths["/posts/{id}"].(engine.J)["get"].(engine.J)["responses"].(engine.J)["200"]; !ok {
+		t.Errorf("GET /posts/{id} has no 200 response")
+	}
+}
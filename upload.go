@@ -0,0 +1,415 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrRequestTooLarge is returned when a request body exceeds UploadConfig.MaxTotalSize.
+var ErrRequestTooLarge = errors.New("Request body too large")
+
+// UploadConfig configures ParseUploads.
+type UploadConfig struct {
+	// Sink stores uploaded files. Defaults to a TempDirSink in os.TempDir.
+	Sink UploadSink
+	// MaxFileSize is the largest file that may be uploaded. Defaults to 32MB.
+	MaxFileSize int64
+	// MaxTotalSize is the most data that may be read from one request's body,
+	// counting every part, including those that are discarded. Defaults to
+	// 64MB.
+	MaxTotalSize int64
+	// MaxFieldSize is the longest non-file field value. Defaults to 1MB.
+	MaxFieldSize int64
+	// AllowedTypes lists the content types files may have, as sniffed from
+	// their contents. Wildcards such as "image/*" are allowed. If empty any
+	// type is allowed.
+	AllowedTypes []string
+}
+
+// DefaultUploadConfig is used by ParseUploads and BindForm when no config is given.
+var DefaultUploadConfig = &UploadConfig{}
+
+// UploadedFile is a file that has been streamed to an UploadSink.
+type UploadedFile struct {
+	Field    string
+	Filename string
+	// ContentType is sniffed from the file's contents rather than taken from
+	// the client.
+	ContentType string
+	Size        int64
+	// SHA256 is the hex encoded SHA-256 checksum of the file.
+	SHA256 string
+	// Key identifies the file within its sink, e.g. its path for a TempDirSink.
+	Key string
+
+	sink UploadSink
+}
+
+// Open returns the contents of the file.
+func (f *UploadedFile) Open() (io.ReadCloser, error) {
+	return f.sink.Open(f)
+}
+
+// Remove deletes the file from its sink.
+func (f *UploadedFile) Remove() error {
+	return f.sink.Remove(f)
+}
+
+// UploadSink stores uploaded files.
+type UploadSink interface {
+	// Create returns a writer that the file's contents are streamed to, and
+	// sets the file's Key.
+	Create(file *UploadedFile) (io.WriteCloser, error)
+	Open(file *UploadedFile) (io.ReadCloser, error)
+	Remove(file *UploadedFile) error
+}
+
+// TempDirSink stores uploads as temporary files in Dir, or os.TempDir if Dir
+// is empty. Files are removed when the request ends, so handlers must move
+// any they want to keep.
+type TempDirSink struct {
+	Dir string
+}
+
+func (s TempDirSink) Create(file *UploadedFile) (io.WriteCloser, error) {
+	f, err := os.CreateTemp(s.Dir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	file.Key = f.Name()
+	return f, nil
+}
+
+func (s TempDirSink) Open(file *UploadedFile) (io.ReadCloser, error) {
+	return os.Open(file.Key)
+}
+
+func (s TempDirSink) Remove(file *UploadedFile) error {
+	err := os.Remove(file.Key)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// MemorySink stores uploads in memory, which is mostly useful in tests.
+type MemorySink struct {
+	mu    sync.Mutex
+	seq   int
+	files map[string][]byte
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	sink *MemorySink
+	key  string
+}
+
+func (w *memoryWriter) Close() error {
+	w.sink.mu.Lock()
+	defer w.sink.mu.Unlock()
+	w.sink.files[w.key] = w.Bytes()
+	return nil
+}
+
+func (s *MemorySink) Create(file *UploadedFile) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string][]byte)
+	}
+	s.seq++
+	file.Key = strconv.Itoa(s.seq)
+	return &memoryWriter{sink: s, key: file.Key}, nil
+}
+
+func (s *MemorySink) Open(file *UploadedFile) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[file.Key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemorySink) Remove(file *UploadedFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, file.Key)
+	return nil
+}
+
+// Len returns the number of files held.
+func (s *MemorySink) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+// Uploads holds the fields and files of a multipart request.
+type Uploads struct {
+	Values url.Values
+	Files  map[string][]*UploadedFile
+}
+
+// RemoveAll removes every uploaded file.
+func (u *Uploads) RemoveAll() {
+	for _, files := range u.Files {
+		for _, f := range files {
+			f.Remove()
+		}
+	}
+}
+
+// Bind fills the fields of dst tagged with `form:"name"` from the uploaded
+// values and files, as BindForm does.
+func (u *Uploads) Bind(dst interface{}) error {
+	errs := bind(dst, valuesSource("form", u.Values))
+	errs = append(errs, bindFiles(reflect.ValueOf(dst).Elem(), u.Files)...)
+	return validateAfter(dst, errs)
+}
+
+// ParseUploads streams the parts of a multipart/form-data request. Files are
+// written to config.Sink as they're read, with their type sniffed and
+// checksum computed on the way, so they're never held in memory whole. Files
+// that break config's rules are reported as ValidationErrors. The files are
+// removed once the router has finished handling the request and the handler
+// has returned.
+func ParseUploads(req *http.Request, config *UploadConfig) (*Uploads, error) {
+	if config == nil {
+		config = DefaultUploadConfig
+	}
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, http.ErrNotMultipart
+	}
+	if params["boundary"] == "" {
+		return nil, http.ErrMissingBoundary
+	}
+	sink := config.Sink
+	if sink == nil {
+		sink = TempDirSink{}
+	}
+
+	// Everything read from the body counts towards the limit, including
+	// parts that are skipped or discarded.
+	body := &limitedReader{r: req.Body, remaining: orDefault(config.MaxTotalSize, 64<<20)}
+	mr := multipart.NewReader(body, params["boundary"])
+	u := &Uploads{Values: make(url.Values), Files: make(map[string][]*UploadedFile)}
+	GetContext(req).OnFinish(u.RemoveAll)
+	fail := func(err error) (*Uploads, error) {
+		u.RemoveAll()
+		if body.exceeded {
+			return nil, ErrRequestTooLarge
+		}
+		return nil, err
+	}
+	var errs ValidationErrors
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			limit := orDefault(config.MaxFieldSize, 1<<20)
+			var buf bytes.Buffer
+			n, err := io.Copy(&buf, io.LimitReader(part, limit+1))
+			if err != nil {
+				return fail(err)
+			}
+			if n > limit {
+				errs = append(errs, FieldError{Field: name, Source: "form", Message: fmt.Sprintf("must be at most %d bytes", limit)})
+				continue
+			}
+			u.Values.Add(name, buf.String())
+			continue
+		}
+
+		file, fe, err := storeUpload(part, sink, config)
+		if err != nil {
+			return fail(err)
+		}
+		if fe != "" {
+			errs = append(errs, FieldError{Field: name, Source: "form", Message: fe})
+			continue
+		}
+		u.Files[name] = append(u.Files[name], file)
+	}
+	if len(errs) > 0 {
+		u.RemoveAll()
+		return nil, errs
+	}
+	return u, nil
+}
+
+// limitedReader reads from r until more than remaining bytes have been read,
+// and then fails with ErrRequestTooLarge.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, ErrRequestTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded = true
+		return 0, ErrRequestTooLarge
+	}
+	return n, err
+}
+
+// storeUpload streams part to sink. A non-empty message is returned if the
+// file breaks config's rules, in which case nothing is kept.
+func storeUpload(part *multipart.Part, sink UploadSink, config *UploadConfig) (*UploadedFile, string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", err
+	}
+	head = head[:n]
+
+	file := &UploadedFile{
+		Field:       part.FormName(),
+		Filename:    part.FileName(),
+		ContentType: http.DetectContentType(head),
+		sink:        sink,
+	}
+	if !typeAllowed(file.ContentType, config.AllowedTypes) {
+		if _, err := io.Copy(io.Discard, part); err != nil {
+			return nil, "", err
+		}
+		return nil, "must be one of the types " + strings.Join(config.AllowedTypes, ", "), nil
+	}
+
+	w, err := sink.Create(file)
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.New()
+	limit := orDefault(config.MaxFileSize, 32<<20)
+	size, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(io.MultiReader(bytes.NewReader(head), part), limit+1))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && size > limit {
+		_, err = io.Copy(io.Discard, part)
+	}
+	switch {
+	case err != nil:
+		file.Remove()
+		return nil, "", err
+	case size > limit:
+		file.Remove()
+		return nil, fmt.Sprintf("must be at most %d bytes", limit), nil
+	}
+	file.Size = size
+	file.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return file, "", nil
+}
+
+func typeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if a == mediaType || strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func orDefault(n, def int64) int64 {
+	if n == 0 {
+		return def
+	}
+	return n
+}
+
+// BindForm fills the fields of dst tagged with `form:"name"` from a URL
+// encoded or multipart form body, then validates it as BindQuery does. Fields
+// of type *UploadedFile or []*UploadedFile receive uploaded files, which are
+// parsed with DefaultUploadConfig. Use ParseUploads and Uploads.Bind for
+// other limits.
+func BindForm(req *http.Request, dst interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		u, err := ParseUploads(req, nil)
+		if err != nil {
+			return err
+		}
+		return u.Bind(dst)
+	}
+	if err := req.ParseForm(); err != nil {
+		return ValidationErrors{{Source: "form", Message: "Invalid form: " + err.Error()}}
+	}
+	return bindAndValidate(dst, valuesSource("form", req.PostForm))
+}
+
+var (
+	uploadedFileType  = reflect.TypeOf((*UploadedFile)(nil))
+	uploadedFilesType = reflect.TypeOf([]*UploadedFile(nil))
+)
+
+func bindFiles(rv reflect.Value, files map[string][]*UploadedFile) (errs ValidationErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		fv := rv.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			errs = append(errs, bindFiles(fv, files)...)
+			continue
+		}
+		name := tagName(sf.Tag.Get("form"))
+		if name == "" || sf.PkgPath != "" {
+			continue
+		}
+		switch sf.Type {
+		case uploadedFileType:
+			if f := files[name]; len(f) > 0 {
+				if len(f) > 1 {
+					errs = append(errs, FieldError{Field: name, Source: "form", Message: "must be a single file"})
+					continue
+				}
+				fv.Set(reflect.ValueOf(f[0]))
+			}
+		case uploadedFilesType:
+			fv.Set(reflect.ValueOf(files[name]))
+		}
+	}
+	return errs
+}
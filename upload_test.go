@@ -0,0 +1,221 @@
+package engine_test
+
+import (
+	"bytes"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// part is a field, or a file if filename is set, of a multipart body.
+type part struct {
+	name, filename, content string
+}
+
+func multipartBody(t *testing.T, parts ...part) (string, io.Reader) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.filename != "" {
+			w, err = mw.CreateFormFile(p.name, p.filename)
+		} else {
+			w, err = mw.CreateFormField(p.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, p.content)
+	}
+	mw.Close()
+	return mw.FormDataContentType(), &buf
+}
+
+// readUpload returns the contents of f.
+func readUpload(t *testing.T, f *engine.UploadedFile) string {
+	t.Helper()
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	return string(data)
+}
+
+func TestParseUploads(t *testing.T) {
+	sink := &engine.MemorySink{}
+	r := engine.NewRouter()
+	r.Post("/upload", func(rw http.ResponseWriter, req *http.Request) {
+		u, err := engine.ParseUploads(req, &engine.UploadConfig{Sink: sink})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (url.Values{"title": {"Notes"}, "tag": {"a", "b"}}); !reflect.DeepEqual(u.Values, want) {
+			t.Errorf("Values = %v, want %v", u.Values, want)
+		}
+		files := u.Files["doc"]
+		if len(files) != 1 {
+			t.Fatalf("got %d files, want 1", len(files))
+		}
+		f := files[0]
+		if f.Filename != "notes.txt" || f.ContentType != "text/plain; charset=utf-8" || f.Size != 11 ||
+			f.SHA256 != "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+			t.Errorf("file = %+v", f)
+		}
+		if got := readUpload(t, f); got != "hello world" {
+			t.Errorf("contents = %q", got)
+		}
+		if sink.Len() != 1 {
+			t.Errorf("sink holds %d files, want 1", sink.Len())
+		}
+	})
+	ct, body := multipartBody(t,
+		part{name: "title", content: "Notes"},
+		part{name: "tag", content: "a"},
+		part{name: "tag", content: "b"},
+		part{name: "doc", filename: "notes.txt", content: "hello world"},
+	)
+	enginetest.New(t, r).Post("/upload").Body(ct, body).Do()
+	if sink.Len() != 0 {
+		t.Errorf("sink holds %d files after the request, want 0", sink.Len())
+	}
+}
+
+func TestParseUploadsLimits(t *testing.T) {
+	big := strings.Repeat("x", 4096)
+	config := engine.UploadConfig{
+		MaxFileSize:  8,
+		MaxFieldSize: 4,
+		MaxTotalSize: 1024,
+		AllowedTypes: []string{"text/*"},
+	}
+	tests := []struct {
+		name  string
+		parts []part
+		err   error
+	}{
+		{"file too big", []part{{name: "doc", filename: "a.txt", content: "123456789"}},
+			engine.ValidationErrors{{Field: "doc", Source: "form", Message: "must be at most 8 bytes"}}},
+		{"field too big", []part{{name: "title", content: "12345"}},
+			engine.ValidationErrors{{Field: "title", Source: "form", Message: "must be at most 4 bytes"}}},
+		{"type not allowed", []part{{name: "doc", filename: "a.png", content: "\x89PNG\r\n\x1a\n"}},
+			engine.ValidationErrors{{Field: "doc", Source: "form", Message: "must be one of the types text/*"}}},
+		{"too large", []part{{name: "doc", filename: "a.txt", content: "1"}, {name: "b", content: big}}, engine.ErrRequestTooLarge},
+		{"discarded file too large", []part{{name: "doc", filename: "a.txt", content: big}}, engine.ErrRequestTooLarge},
+		{"disallowed file too large", []part{{name: "doc", filename: "a.png", content: "\x89PNG\r\n\x1a\n" + big}}, engine.ErrRequestTooLarge},
+		{"unnamed part too large", []part{{name: "", content: big}}, engine.ErrRequestTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &engine.MemorySink{}
+			var err error
+			r := engine.NewRouter()
+			r.Post("/upload", func(rw http.ResponseWriter, req *http.Request) {
+				c := config
+				c.Sink = sink
+				_, err = engine.ParseUploads(req, &c)
+				if sink.Len() != 0 {
+					t.Errorf("sink holds %d files after an error", sink.Len())
+				}
+			})
+			ct, body := multipartBody(t, tt.parts...)
+			enginetest.New(t, r).Post("/upload").Body(ct, body).Do()
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseUploadsNotMultipart(t *testing.T) {
+	req := enginetest.New(t, nil).Post("/upload").Form(url.Values{"a": {"b"}}).Build()
+	if _, err := engine.ParseUploads(req, nil); err != http.ErrNotMultipart {
+		t.Errorf("error = %v, want http.ErrNotMultipart", err)
+	}
+}
+
+func TestUploadsRemovedAfterTimedOutHandler(t *testing.T) {
+	sink := &engine.MemorySink{}
+	contents := make(chan string, 1)
+	r := engine.NewRouter()
+	r.Use(engine.Timeout(10 * time.Millisecond))
+	r.Post("/upload", func(rw http.ResponseWriter, req *http.Request) {
+		u, err := engine.ParseUploads(req, &engine.UploadConfig{Sink: sink})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		<-req.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+		contents <- readUpload(t, u.Files["doc"][0])
+	})
+	ct, body := multipartBody(t, part{name: "doc", filename: "a.txt", content: "still here"})
+	enginetest.New(t, r).Post("/upload").Body(ct, body).Do().AssertStatus(http.StatusServiceUnavailable)
+
+	// The file outlives the request until the handler returns.
+	if got := <-contents; got != "still here" {
+		t.Errorf("contents after the timeout = %q", got)
+	}
+	waitFor(t, func() bool { return sink.Len() == 0 })
+}
+
+type NoteForm struct {
+	Title       string                 `form:"title" validate:"required"`
+	Attachment  *engine.UploadedFile   `form:"attachment"`
+	Attachments []*engine.UploadedFile `form:"extra"`
+}
+
+func TestBindForm(t *testing.T) {
+	var got NoteForm
+	var err error
+	r := engine.NewRouter()
+	r.Post("/notes", func(rw http.ResponseWriter, req *http.Request) {
+		got = NoteForm{}
+		err = engine.BindForm(req, &got)
+		if got.Attachment != nil {
+			if s := readUpload(t, got.Attachment); s != "hi" {
+				t.Errorf("attachment = %q, want hi", s)
+			}
+		}
+	})
+	c := enginetest.New(t, r)
+
+	ct, body := multipartBody(t,
+		part{name: "title", content: "Hello"},
+		part{name: "attachment", filename: "a.txt", content: "hi"},
+		part{name: "extra", filename: "b.txt", content: "b"},
+		part{name: "extra", filename: "c.txt", content: "c"},
+	)
+	c.Post("/notes").Body(ct, body).Do()
+	if err != nil || got.Title != "Hello" || got.Attachment == nil || len(got.Attachments) != 2 {
+		t.Errorf("BindForm = %+v, %v", got, err)
+	}
+
+	ct, body = multipartBody(t,
+		part{name: "attachment", filename: "a.txt", content: "hi"},
+		part{name: "attachment", filename: "b.txt", content: "hi"},
+	)
+	c.Post("/notes").Body(ct, body).Do()
+	want := engine.ValidationErrors{
+		{Field: "attachment", Source: "form", Message: "must be a single file"},
+		{Field: "title", Source: "form", Message: "is required"},
+	}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("BindForm errors = %v, want %v", err, want)
+	}
+
+	c.Post("/notes").Form(url.Values{"title": {"Plain"}}).Do()
+	if err != nil || got.Title != "Plain" {
+		t.Errorf("BindForm = %+v, %v", got, err)
+	}
+}
@@ -18,6 +18,8 @@ var (
 
 func ColourForStatus(code int) string {
 	switch {
+	case code >= 100 && code < 200:
+		return blue
 	case code >= 200 && code < 300:
 		return green
 	case code >= 300 && code < 400:
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes a problem with a single field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is returned when request fields are missing or invalid.
+// JSONError includes the individual errors in its response.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		if fe.Field == "" {
+			msgs[i] = fe.Message
+			continue
+		}
+		msgs[i] = fe.Field + " " + fe.Message
+	}
+	return strings.Join(msgs, ", ")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Validate checks v, a pointer to a struct, against the rules in its fields'
+// validate tags:
+//
+//	required    the field must not be its zero value
+//	min=n       numbers must be at least n, strings and slices at least n long
+//	max=n       numbers must be at most n, strings and slices at most n long
+//	oneof=a b   the field must be one of the space separated values
+//
+// Nested and embedded structs are validated too.
+func Validate(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	if errs := validateStruct(rv, ""); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(rv reflect.Value, prefix string) (errs ValidationErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		fv := rv.Field(i)
+		name, source := fieldName(sf)
+		if name == "-" {
+			continue
+		}
+		if rules := sf.Tag.Get("validate"); rules != "" {
+			if msg := validateField(fv, rules); msg != "" {
+				errs = append(errs, FieldError{Field: prefix + name, Source: source, Message: msg})
+				continue
+			}
+		}
+		inner := reflect.Indirect(fv)
+		if inner.Kind() == reflect.Struct && inner.Type() != timeType {
+			if sf.Anonymous {
+				errs = append(errs, validateStruct(inner, prefix)...)
+			} else {
+				errs = append(errs, validateStruct(inner, prefix+name+".")...)
+			}
+		}
+	}
+	return errs
+}
+
+// fieldName returns the name a client knows a field by and where it comes from.
+func fieldName(sf reflect.StructField) (string, string) {
+	for _, s := range []struct{ tag, source string }{
+		{"param", "path"},
+		{"query", "query"},
+		{"header", "header"},
+		{"form", "form"},
+	} {
+		if name := tagName(sf.Tag.Get(s.tag)); name != "" {
+			return name, s.source
+		}
+	}
+	if name := tagName(sf.Tag.Get("json")); name != "" {
+		return name, "body"
+	}
+	return sf.Name, "body"
+}
+
+func tagName(tag string) string {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+func validateField(fv reflect.Value, rules string) string {
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		if name == "required" {
+			if fv.IsZero() {
+				return "is required"
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("engine: invalid %s rule %q", name, rule))
+			}
+			n, unit := measure(fv)
+			if name == "min" && n < limit {
+				return strings.TrimSpace("must be at least " + arg + " " + unit)
+			}
+			if name == "max" && n > limit {
+				return strings.TrimSpace("must be at most " + arg + " " + unit)
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			value := fmt.Sprint(fv.Interface())
+			found := false
+			for _, o := range options {
+				if o == value {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return "must be one of " + strings.Join(options, ", ")
+			}
+		default:
+			panic(fmt.Sprintf("engine: unknown validation rule %q", rule))
+		}
+	}
+	return ""
+}
+
+// measure returns a number's value, or the length of a string or collection
+// along with its unit.
+func measure(fv reflect.Value) (float64, string) {
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), ""
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), "characters long"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(fv.Len()), "items long"
+	}
+	return 0, ""
+}
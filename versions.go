@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VersionConfig configures how requests choose an API version. A request's
+// version comes from its path prefix, then its header, then its Accept media
+// type, and otherwise is the default.
+type VersionConfig struct {
+	// PathPrefix also serves each version's routes under /v<version>, e.g.
+	// /v2/users as well as /users.
+	PathPrefix bool
+	// Header names a request header carrying the version, e.g. "API-Version".
+	// Responses carry the version they were served by in the same header.
+	Header string
+	// MediaType is a vendor media type, e.g. "application/vnd.acme". Requests
+	// choose a version with "Accept: application/vnd.acme.v2+json" or
+	// "Accept: application/vnd.acme+json; version=2".
+	MediaType string
+	// Default is the version used when the request doesn't choose one.
+	// Defaults to the last version added.
+	Default string
+}
+
+// VersionOptions describes a version's lifecycle.
+type VersionOptions struct {
+	// Deprecation, if set, is when the version was deprecated. Responses carry
+	// it in a Deprecation header.
+	Deprecation time.Time
+	// Sunset, if set, is when the version will stop being served. Responses
+	// carry it in a Sunset header (RFC 8594).
+	Sunset time.Time
+	// Link, if set, is the URL of documentation about the deprecation.
+	Link string
+}
+
+// Versions dispatches requests between versions of the same routes.
+//
+//	versions := r.Versions(&engine.VersionConfig{Header: "API-Version", Default: "1"})
+//	v1 := versions.Version("1", &engine.VersionOptions{Deprecation: deprecated, Sunset: sunset})
+//	v1.Get("/users", listUsersV1)
+//	v2 := versions.Version("2", nil)
+//	v2.Get("/users", listUsersV2)
+type Versions struct {
+	router *Router
+	config VersionConfig
+	// notFound and notAcceptable are wrapped in the router's middleware.
+	notFound      http.Handler
+	notAcceptable http.Handler
+
+	mu         sync.RWMutex
+	versions   map[string]*apiVersion
+	order      []string
+	dispatches map[string]map[string]versionRoute
+}
+
+type apiVersion struct {
+	name     string
+	options  VersionOptions
+	versions *Versions
+}
+
+type versionRoute struct {
+	route   *Route
+	handler http.Handler
+}
+
+// Versions returns a Versions whose routes are registered on the router, in
+// its middleware.
+func (r *Router) Versions(config *VersionConfig) *Versions {
+	if config == nil {
+		config = &VersionConfig{}
+	}
+	vs := &Versions{
+		router:     r,
+		config:     *config,
+		versions:   make(map[string]*apiVersion),
+		dispatches: make(map[string]map[string]versionRoute),
+	}
+	vs.notFound = r.lazyChain(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r.notFoundHandler().ServeHTTP(rw, req)
+	}), nil)
+	vs.notAcceptable = r.lazyChain(http.HandlerFunc(vs.renderNotAcceptable), nil)
+	return vs
+}
+
+// Version adds a version and returns a sub router for registering its
+// routes, wrapped in middleware. Versions are named like "2", and "v2" is the
+// same version. A nil options means the version isn't deprecated.
+func (vs *Versions) Version(name string, options *VersionOptions, middleware ...MiddlewareFunc) *Router {
+	if options == nil {
+		options = &VersionOptions{}
+	}
+	name = normalizeVersion(name)
+	v := &apiVersion{name: name, options: *options, versions: vs}
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	if vs.versions[name] != nil {
+		panic("engine: API version " + name + " added twice")
+	}
+	vs.versions[name] = v
+	vs.order = append(vs.order, name)
+	return &Router{
+		mux:          vs.router.mux,
+		routes:       vs.router.routes,
+		absolutePath: vs.router.absolutePath,
+		middleware:   newMiddlewareEntries(middleware),
+		version:      v,
+		parent:       vs.router,
+	}
+}
+
+// Paths returns the versions each path is served in, in the order they were
+// added.
+func (vs *Versions) Paths() map[string][]string {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	found := make(map[string]map[string]bool)
+	for _, routes := range vs.dispatches {
+		for name, vr := range routes {
+			if found[vr.route.Path] == nil {
+				found[vr.route.Path] = make(map[string]bool)
+			}
+			found[vr.route.Path][name] = true
+		}
+	}
+	paths := make(map[string][]string, len(found))
+	for p, names := range found {
+		for _, name := range vs.order {
+			if names[name] {
+				paths[p] = append(paths[p], name)
+			}
+		}
+	}
+	return paths
+}
+
+// Default returns the version used when a request doesn't choose one.
+func (vs *Versions) Default() string {
+	if vs.config.Default != "" {
+		return normalizeVersion(vs.config.Default)
+	}
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	if len(vs.order) == 0 {
+		return ""
+	}
+	return vs.order[len(vs.order)-1]
+}
+
+// handle registers a version's handler, already wrapped in its router's
+// middleware, at absolutePath.
+func (v *apiVersion) handle(method, absolutePath string, handler http.Handler, router *Router) *Route {
+	vs := v.versions
+	route := &Route{Method: method, Path: absolutePath, Version: v.name, router: router, versions: vs, source: caller()}
+	handler = v.headers(handler)
+	if vs.config.PathPrefix {
+		vs.router.register(method, route.prefixedPath(), wrap(handler, route))
+	}
+
+	key := method + " " + absolutePath
+	vs.mu.Lock()
+	routes, ok := vs.dispatches[key]
+	if !ok {
+		routes = make(map[string]versionRoute)
+		vs.dispatches[key] = routes
+	}
+	routes[v.name] = versionRoute{route: route, handler: handler}
+	vs.mu.Unlock()
+	if !ok {
+		vs.router.register(method, absolutePath, wrap(vs.dispatch(routes), nil))
+	}
+	vs.router.routes.add(route)
+	return route
+}
+
+// headers sets the version's response headers.
+func (v *apiVersion) headers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		h := rw.Header()
+		if v.versions.config.Header != "" {
+			h.Set(v.versions.config.Header, v.name)
+		}
+		if !v.options.Deprecation.IsZero() {
+			h.Set("Deprecation", fmt.Sprintf("@%d", v.options.Deprecation.Unix()))
+		}
+		if !v.options.Sunset.IsZero() {
+			h.Set("Sunset", v.options.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if v.options.Link != "" {
+			h.Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, v.options.Link))
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// dispatch serves requests with the handler for their version. Unknown
+// versions get a 406, and known versions without the route a 404.
+func (vs *Versions) dispatch(routes map[string]versionRoute) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if vs.config.Header != "" {
+			rw.Header().Add("Vary", vs.config.Header)
+		}
+		if vs.config.MediaType != "" {
+			rw.Header().Add("Vary", "Accept")
+		}
+		name := vs.requested(req)
+		vs.mu.RLock()
+		vr, found := routes[name]
+		known := vs.versions[name] != nil
+		vs.mu.RUnlock()
+		switch {
+		case found:
+			GetContext(req).Route = vr.route
+			vr.handler.ServeHTTP(rw, req)
+		case known:
+			vs.notFound.ServeHTTP(rw, req)
+		default:
+			vs.notAcceptable.ServeHTTP(rw, req)
+		}
+	})
+}
+
+// renderNotAcceptable renders a 406 for unknown versions, listing the known
+// ones in its "versions" extension.
+func (vs *Versions) renderNotAcceptable(rw http.ResponseWriter, req *http.Request) {
+	vs.mu.RLock()
+	versions := append([]string(nil), vs.order...)
+	vs.mu.RUnlock()
+	RenderError(rw, req, &StatusError{
+		Code:       http.StatusNotAcceptable,
+		Err:        fmt.Errorf("Unsupported API version %q", vs.requested(req)),
+		Extensions: J{"versions": versions},
+	}, http.StatusNotAcceptable)
+}
+
+// requested returns the version the request chose, or the default.
+func (vs *Versions) requested(req *http.Request) string {
+	if vs.config.Header != "" {
+		if v := req.Header.Get(vs.config.Header); v != "" {
+			return normalizeVersion(v)
+		}
+	}
+	if vs.config.MediaType != "" {
+		for _, accept := range strings.Split(strings.Join(req.Header["Accept"], ","), ",") {
+			mediaType, params, err := mime.ParseMediaType(accept)
+			if err != nil || !strings.HasPrefix(mediaType, vs.config.MediaType) {
+				continue
+			}
+			rest := mediaType[len(vs.config.MediaType):]
+			if strings.HasPrefix(rest, ".v") {
+				if i := strings.IndexByte(rest, '+'); i >= 0 {
+					rest = rest[:i]
+				}
+				return normalizeVersion(rest[1:])
+			}
+			if (rest == "" || rest[0] == '+') && params["version"] != "" {
+				return normalizeVersion(params["version"])
+			}
+		}
+	}
+	return vs.Default()
+}
+
+// prefixedPath returns the path a versioned route is served at with
+// VersionConfig.PathPrefix.
+func (rt *Route) prefixedPath() string {
+	base := strings.TrimSuffix(rt.versions.router.absolutePath, "/")
+	return base + "/v" + rt.Version + strings.TrimPrefix(rt.Path, base)
+}
+
+// documentedPath returns the path the route is documented at, or false if it
+// isn't documented. Routes of non-default versions are only documented under
+// their path prefix.
+func (rt *Route) documentedPath() (string, bool) {
+	switch {
+	case rt.versions == nil:
+		return rt.Path, true
+	case rt.versions.config.PathPrefix:
+		return rt.prefixedPath(), true
+	default:
+		return rt.Path, rt.Version == rt.versions.Default()
+	}
+}
+
+func normalizeVersion(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(name), "v"), "V")
+}
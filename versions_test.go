@@ -0,0 +1,88 @@
+package engine_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func version(name string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		engine.JSON(rw, engine.J{"version": name, "id": engine.GetContext(req).Params.ByName("id")}, http.StatusOK)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	deprecated := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := engine.NewRouter()
+	api := r.SubRouter("/api")
+	versions := api.Versions(&engine.VersionConfig{PathPrefix: true, Header: "API-Version", MediaType: "application/vnd.acme", Default: "1"})
+	v1 := versions.Version("v1", &engine.VersionOptions{Deprecation: deprecated, Sunset: sunset, Link: "https://example.com/v2"})
+	v2 := versions.Version("2", nil)
+	v1.Get("/users/:id", version("1"))
+	v2.Get("/users/:id", version("2"))
+	v2.Get("/teams", version("2"))
+
+	c := enginetest.New(t, r)
+	c.Get("/api/users/7").Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("version", "1").
+		AssertJSONPath("id", "7").
+		AssertHeader("API-Version", "1").
+		AssertHeader("Deprecation", "@1767225600").
+		AssertHeader("Sunset", "Fri, 01 Jan 2027 00:00:00 GMT").
+		AssertHeader("Link", `<https://example.com/v2>; rel="deprecation"`)
+	c.Get("/api/users/7").Header("API-Version", "2").Do().
+		AssertStatus(http.StatusOK).
+		AssertJSONPath("version", "2").
+		AssertHeader("Deprecation", "").
+		AssertHeader("Vary", "API-Version")
+	c.Get("/api/users/7").Header("Accept", "text/html, application/vnd.acme.v2+json").Do().AssertJSONPath("version", "2")
+	c.Get("/api/users/7").Header("Accept", "application/vnd.acme+json; version=2").Do().AssertJSONPath("version", "2")
+	c.Get("/api/v2/users/7").Header("API-Version", "1").Do().AssertJSONPath("version", "2").AssertJSONPath("id", "7")
+	c.Get("/api/v1/users/7").Do().AssertJSONPath("version", "1")
+
+	c.Get("/api/users/7").Header("API-Version", "3").Do().
+		AssertStatus(http.StatusNotAcceptable).
+		AssertJSONPath("versions", []string{"1", "2"})
+	c.Get("/api/teams").Do().AssertStatus(http.StatusNotFound)
+	c.Get("/api/teams").Header("API-Version", "2").Do().AssertStatus(http.StatusOK)
+
+	want := map[string][]string{"/api/users/:id": {"1", "2"}, "/api/teams": {"2"}}
+	if got := versions.Paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Paths() = %v, want %v", got, want)
+	}
+	doc := r.OpenAPI(nil)
+	paths := doc["paths"].(engine.J)
+	for _, p := range []string{"/api/v1/users/{id}", "/api/v2/users/{id}", "/api/v2/teams"} {
+		if paths[p] == nil {
+			t.Errorf("OpenAPI document is missing %s", p)
+		}
+	}
+}
+
+func TestVersionsErrorRenderer(t *testing.T) {
+	r := engine.NewRouter(engine.WithErrorRenderer(func(rw http.ResponseWriter, req *http.Request, err error, code int) {
+		var se *engine.StatusError
+		if errors.As(err, &se) {
+			rw.Header().Set("Supported-Versions", fmt.Sprint(se.Extensions["versions"]))
+		}
+		rw.WriteHeader(code)
+		rw.Write([]byte("error: " + err.Error()))
+	}))
+	versions := r.Versions(&engine.VersionConfig{Header: "API-Version", Default: "1"})
+	versions.Version("1", nil).Get("/users", version("1"))
+	versions.Version("2", nil).Get("/users", version("2"))
+
+	enginetest.New(t, r).Get("/users").Header("API-Version", "3").Do().
+		AssertStatus(http.StatusNotAcceptable).
+		AssertHeader("Supported-Versions", "[1 2]").
+		AssertBody(`error: Unsupported API version "3"`)
+}
@@ -0,0 +1,482 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket message types.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	opContinuation = 0
+	opText         = 1
+	opBinary       = 2
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// WebSocket close codes, as defined in RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidPayload  = 1007
+	CloseMessageTooBig   = 1009
+	closeNoStatus        = 1005
+	websocketAcceptMagic = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// ErrWebSocketClosed is returned when writing to a closed connection.
+var ErrWebSocketClosed = errors.New("engine: websocket closed")
+
+// CloseError is returned by ReadMessage when the peer closes the connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("engine: websocket closed with code %d %s", e.Code, e.Reason)
+}
+
+// WebSocketConfig configures WebSocket connections.
+type WebSocketConfig struct {
+	// AllowedOrigins lists the origins browsers may connect from. "*" allows
+	// any origin. If empty only same host requests are allowed.
+	AllowedOrigins []string
+	// Subprotocols lists the supported subprotocols in order of preference.
+	Subprotocols []string
+	// ReadLimit is the largest message, in bytes, that will be read. Larger
+	// messages close the connection. Defaults to 1MB.
+	ReadLimit int64
+	// PingInterval is the time between pings. Defaults to 30 seconds.
+	PingInterval time.Duration
+	// PongWait is how long the connection may be idle before it's considered
+	// dead. It must be longer than PingInterval. Defaults to 60 seconds.
+	PongWait time.Duration
+	// WriteWait is the time allowed to write a message. Defaults to 10 seconds.
+	WriteWait time.Duration
+	// Hub, if set, tracks every upgraded connection so Hub.Shutdown closes
+	// them, whether or not they join a room. Requests arriving once it's shut
+	// down get a 503.
+	Hub *Hub
+}
+
+func (config WebSocketConfig) withDefaults() WebSocketConfig {
+	if config.ReadLimit == 0 {
+		config.ReadLimit = 1 << 20
+	}
+	if config.PingInterval == 0 {
+		config.PingInterval = 30 * time.Second
+	}
+	if config.PongWait == 0 {
+		config.PongWait = 60 * time.Second
+	}
+	if config.WriteWait == 0 {
+		config.WriteWait = 10 * time.Second
+	}
+	return config
+}
+
+func (config WebSocketConfig) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(config.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, req.Host)
+	}
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSocketConn is a server side RFC 6455 WebSocket connection. One goroutine
+// may read from it while others write. The connection is kept alive with
+// pings, and a peer that stops responding is detected by ReadMessage timing
+// out, so handlers should keep reading for as long as the connection is open.
+type WebSocketConn struct {
+	// Context is the Context of the request that opened the connection.
+	Context *Context
+	// Metadata is the metadata of the request that opened the connection, or
+	// nil if MetadataMiddleware isn't in use.
+	Metadata *RequestMetadata
+	// Subprotocol is the negotiated subprotocol, if any.
+	Subprotocol string
+
+	config    WebSocketConfig
+	conn      net.Conn
+	br        *bufio.Reader
+	req       *http.Request
+	writeMu   sync.Mutex
+	closeSent bool
+	closeOnce sync.Once
+	done      chan struct{}
+	hookMu    sync.Mutex
+	onClose   []func()
+}
+
+// WebSocketHandler returns a handler that upgrades requests to WebSocket
+// connections and calls handler with them. The connection is closed when
+// handler returns.
+func WebSocketHandler(config *WebSocketConfig, handler func(*WebSocketConn)) http.Handler {
+	var cfg WebSocketConfig
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if cfg.Hub != nil && cfg.Hub.isClosing() {
+			RenderError(rw, req, ErrHubClosed, http.StatusServiceUnavailable)
+			return
+		}
+		c, err := upgrade(rw, req, cfg)
+		if err != nil {
+			return
+		}
+		defer c.close()
+		if cfg.Hub != nil {
+			if err := cfg.Hub.track(c); err != nil {
+				c.CloseWith(CloseGoingAway, "server shutting down")
+				return
+			}
+		}
+		go c.keepalive()
+		handler(c)
+	})
+}
+
+// WebSocket registers a WebSocket handler for the given path. A nil config
+// uses the defaults.
+func (r *Router) WebSocket(path string, config *WebSocketConfig, handler func(*WebSocketConn), middleware ...MiddlewareFunc) *Route {
+	return r.Handle("GET", path, WebSocketHandler(config, handler), middleware...)
+}
+
+func upgrade(rw http.ResponseWriter, req *http.Request, config WebSocketConfig) (*WebSocketConn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case req.Method != "GET",
+		!headerContainsToken(req.Header, "Connection", "upgrade"),
+		!headerContainsToken(req.Header, "Upgrade", "websocket"),
+		req.Header.Get("Sec-WebSocket-Version") != "13",
+		key == "":
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		err := errors.New("Not a valid websocket handshake")
+		RenderError(rw, req, err, http.StatusBadRequest)
+		return nil, err
+	case !config.checkOrigin(req):
+		err := errors.New("Origin not allowed")
+		RenderError(rw, req, err, http.StatusForbidden)
+		return nil, err
+	}
+
+	var subprotocol string
+	for _, p := range config.Subprotocols {
+		if headerContainsToken(req.Header, "Sec-WebSocket-Protocol", p) {
+			subprotocol = p
+			break
+		}
+	}
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		err := errors.New("the ResponseWriter doesn't support the Hijacker interface")
+		RenderError(rw, req, err, http.StatusInternalServerError)
+		return nil, err
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.Sum([]byte(key + websocketAcceptMagic))
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n"
+	if subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ctx := GetContext(req)
+	md, _ := GetMetadata(ctx)
+	if md != nil {
+		md.Stream = true
+	}
+	return &WebSocketConn{
+		Context:     ctx,
+		Metadata:    md,
+		Subprotocol: subprotocol,
+		config:      config,
+		conn:        conn,
+		br:          buf.Reader,
+		req:         req,
+		done:        make(chan struct{}),
+	}, nil
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Request returns the request that opened the connection.
+func (c *WebSocketConn) Request() *http.Request {
+	return c.req
+}
+
+// Done is closed once the connection has been closed.
+func (c *WebSocketConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// ReadMessage reads the next text or binary message, answering pings and
+// close frames as it goes.
+func (c *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.config.PongWait))
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch op {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &CloseError{Code: closeNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.writeFrame(opClose, payload)
+			c.close()
+			return 0, nil, closeErr
+		case opText, opBinary:
+			if messageType != 0 {
+				return 0, nil, c.fail(errors.New("engine: websocket message interrupted"))
+			}
+			messageType, data = int(op), payload
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(errors.New("engine: unexpected websocket continuation frame"))
+			}
+			data = append(data, payload...)
+		default:
+			return 0, nil, c.fail(fmt.Errorf("engine: unknown websocket opcode %d", op))
+		}
+		if int64(len(data)) > c.config.ReadLimit {
+			c.CloseWith(CloseMessageTooBig, "")
+			return 0, nil, c.fail(errors.New("engine: websocket message exceeds read limit"))
+		}
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				c.CloseWith(CloseInvalidPayload, "")
+				return 0, nil, c.fail(errors.New("engine: invalid utf-8 in websocket text message"))
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+// ReadJSON reads the next message and decodes it into v.
+func (c *WebSocketConn) ReadJSON(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage writes data as a single message of the given type.
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	return c.writeFrame(byte(messageType), data)
+}
+
+// WriteJSON writes v as a JSON text message.
+func (c *WebSocketConn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+// CloseWith starts the closing handshake. ReadMessage returns a *CloseError
+// once the peer acknowledges it, or an error if the peer doesn't in time.
+func (c *WebSocketConn) CloseWith(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	err := c.writeFrame(opClose, append(payload, reason...))
+	c.conn.SetReadDeadline(time.Now().Add(c.config.WriteWait))
+	return err
+}
+
+// Close sends a normal close frame and closes the connection.
+func (c *WebSocketConn) Close() error {
+	c.CloseWith(CloseNormal, "")
+	c.close()
+	return nil
+}
+
+func (c *WebSocketConn) close() {
+	c.closeOnce.Do(func() {
+		c.conn.Close()
+		c.hookMu.Lock()
+		close(c.done)
+		hooks := c.onClose
+		c.hookMu.Unlock()
+		for _, fn := range hooks {
+			fn()
+		}
+	})
+}
+
+// addCloseHook arranges for fn to be called when the connection closes. It
+// returns false if the connection is already closed.
+func (c *WebSocketConn) addCloseHook(fn func()) bool {
+	c.hookMu.Lock()
+	defer c.hookMu.Unlock()
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	c.onClose = append(c.onClose, fn)
+	return true
+}
+
+// fail closes the connection after a read error, telling the peer why if
+// the error was its fault.
+func (c *WebSocketConn) fail(err error) error {
+	if _, ok := err.(net.Error); !ok && err != io.EOF && err != io.ErrUnexpectedEOF {
+		c.CloseWith(CloseProtocolError, "")
+	}
+	c.close()
+	return err
+}
+
+func (c *WebSocketConn) keepalive() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.writeFrame(opPing, nil); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *WebSocketConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.br, h[:]); err != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, errors.New("engine: unexpected websocket extension bits")
+	}
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("engine: unmasked websocket frame from client")
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (!fin || n > 125) {
+		return false, 0, nil, errors.New("engine: invalid websocket control frame")
+	}
+	if n > uint64(c.config.ReadLimit) {
+		c.CloseWith(CloseMessageTooBig, "")
+		return false, 0, nil, errors.New("engine: websocket frame exceeds read limit")
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+func (c *WebSocketConn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+	_, err := c.conn.Write(frame)
+	return err
+}
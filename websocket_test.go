@@ -0,0 +1,367 @@
+package engine_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/mnbbrown/engine"
+	"github.com/mnbbrown/engine/enginetest"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pipeRecorder hijacks to the server end of a net.Pipe.
+type pipeRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (w *pipeRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// wsClient is the client end of a WebSocket over a net.Pipe.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func websocketRequest(target string) *http.Request {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	return req
+}
+
+// dial serves a handshake with handler over a net.Pipe. The connection is
+// closed when the test finishes.
+func dial(t *testing.T, handler http.Handler, req *http.Request) (*wsClient, *http.Response) {
+	t.Helper()
+	client, server := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		handler.ServeHTTP(&pipeRecorder{httptest.NewRecorder(), server}, req)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the handler, so it doesn't log after the test finishes.
+	t.Cleanup(func() {
+		client.Close()
+		<-served
+	})
+	return &wsClient{t: t, conn: client, br: br}, res
+}
+
+// send writes a frame, masked unless unmasked is set.
+func (c *wsClient) send(fin bool, op byte, payload []byte, unmasked bool) {
+	c.t.Helper()
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !unmasked {
+		frame[1] |= 0x80
+		mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) sendClose(code int, reason string) {
+	c.t.Helper()
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.send(true, 8, append(payload, reason...), false)
+}
+
+// read reads a frame from the server, which must be unmasked.
+func (c *wsClient) read() (fin bool, op byte, payload []byte) {
+	c.t.Helper()
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if h[1]&0x80 != 0 {
+		c.t.Fatal("server frame is masked")
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return h[0]&0x80 != 0, h[0] & 0x0f, payload
+}
+
+func (c *wsClient) expectMessage(op byte, want string) {
+	c.t.Helper()
+	fin, gotOp, payload := c.read()
+	if !fin || gotOp != op || string(payload) != want {
+		c.t.Errorf("frame = fin %v op %d %q, want op %d %q", fin, gotOp, payload, op, want)
+	}
+}
+
+func (c *wsClient) expectClose(code int) {
+	c.t.Helper()
+	_, op, payload := c.read()
+	if op != 8 || len(payload) < 2 {
+		c.t.Fatalf("frame = op %d %q, want a close frame", op, payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		c.t.Errorf("close code = %d, want %d", got, code)
+	}
+}
+
+// echo returns a handler echoing messages and sending its read error to errs.
+func echo(config *engine.WebSocketConfig, errs chan<- error) http.Handler {
+	return engine.WebSocketHandler(config, func(c *engine.WebSocketConn) {
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			c.WriteMessage(mt, data)
+		}
+	})
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	errs := make(chan error, 1)
+	r := engine.NewRouter()
+	r.WebSocket("/ws", &engine.WebSocketConfig{Subprotocols: []string{"v2", "v1"}}, func(c *engine.WebSocketConn) {
+		c.WriteMessage(engine.TextMessage, []byte(c.Subprotocol))
+		_, _, err := c.ReadMessage()
+		errs <- err
+	})
+
+	req := websocketRequest("/ws")
+	req.Header.Set("Sec-WebSocket-Protocol", "v1, v2")
+	c, res := dial(t, r, req)
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", res.StatusCode)
+	}
+	if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	if got := res.Header.Get("Sec-WebSocket-Protocol"); got != "v2" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want v2", got)
+	}
+	c.expectMessage(1, "v2")
+	c.sendClose(engine.CloseNormal, "bye")
+	c.expectClose(engine.CloseNormal)
+	if err, ok := (<-errs).(*engine.CloseError); !ok || err.Code != engine.CloseNormal || err.Reason != "bye" {
+		t.Errorf("ReadMessage error = %v, want a CloseError 1000 bye", err)
+	}
+
+	tc := enginetest.New(t, r)
+	tc.Get("/ws").Do().AssertStatus(http.StatusBadRequest).AssertHeader("Sec-WebSocket-Version", "13")
+	tc.Get("/ws").
+		Header("Connection", "Upgrade").
+		Header("Upgrade", "websocket").
+		Header("Sec-WebSocket-Version", "13").
+		Header("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==").
+		Header("Origin", "https://evil.example").
+		Do().AssertStatus(http.StatusForbidden)
+}
+
+func TestWebSocketErrorRenderer(t *testing.T) {
+	r := engine.NewRouter(engine.WithErrorRenderer(func(rw http.ResponseWriter, req *http.Request, err error, code int) {
+		rw.WriteHeader(code)
+		rw.Write([]byte("error: " + err.Error()))
+	}))
+	r.WebSocket("/ws", nil, func(c *engine.WebSocketConn) {})
+
+	tc := enginetest.New(t, r)
+	tc.Get("/ws").Do().AssertStatus(http.StatusBadRequest).AssertBody("error: Not a valid websocket handshake")
+	tc.Get("/ws").
+		Header("Connection", "Upgrade").
+		Header("Upgrade", "websocket").
+		Header("Sec-WebSocket-Version", "13").
+		Header("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==").
+		Header("Origin", "https://evil.example").
+		Do().AssertStatus(http.StatusForbidden).AssertBody("error: Origin not allowed")
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	errs := make(chan error, 1)
+	c, _ := dial(t, echo(nil, errs), websocketRequest("/ws"))
+
+	c.send(true, 2, []byte{0, 1, 2}, false)
+	c.expectMessage(2, "\x00\x01\x02")
+
+	// Control frames may be interleaved with a fragmented message.
+	c.send(false, 1, []byte("Hel"), false)
+	c.send(true, 9, []byte("are you there"), false)
+	c.expectMessage(10, "are you there")
+	c.send(false, 0, []byte("lo, "), false)
+	c.send(true, 0, []byte("world"), false)
+	c.expectMessage(1, "Hello, world")
+
+	long := make([]byte, 70000)
+	c.send(true, 2, long, false)
+	c.expectMessage(2, string(long))
+
+	c.send(true, 10, nil, false)
+	c.sendClose(engine.CloseGoingAway, "")
+	c.expectClose(engine.CloseGoingAway)
+	if err, ok := (<-errs).(*engine.CloseError); !ok || err.Code != engine.CloseGoingAway {
+		t.Errorf("ReadMessage error = %v, want a CloseError 1001", err)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *wsClient)
+		code int
+	}{
+		{"unmasked", func(c *wsClient) { c.send(true, 1, []byte("hi"), true) }, engine.CloseProtocolError},
+		{"fragmented control", func(c *wsClient) { c.send(false, 9, nil, false) }, engine.CloseProtocolError},
+		{"long control", func(c *wsClient) { c.send(true, 9, make([]byte, 126), false) }, engine.CloseProtocolError},
+		{"unexpected continuation", func(c *wsClient) { c.send(true, 0, []byte("x"), false) }, engine.CloseProtocolError},
+		{"interrupted message", func(c *wsClient) {
+			c.send(false, 1, []byte("a"), false)
+			c.send(true, 1, []byte("b"), false)
+		}, engine.CloseProtocolError},
+		{"unknown opcode", func(c *wsClient) { c.send(true, 3, nil, false) }, engine.CloseProtocolError},
+		{"invalid utf-8", func(c *wsClient) { c.send(true, 1, []byte{0xff, 0xfe}, false) }, engine.CloseInvalidPayload},
+		{"frame too big", func(c *wsClient) { c.send(true, 2, make([]byte, 9), false) }, engine.CloseMessageTooBig},
+		{"message too big", func(c *wsClient) {
+			c.send(false, 2, make([]byte, 5), false)
+			c.send(true, 0, make([]byte, 5), false)
+		}, engine.CloseMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := make(chan error, 1)
+			c, _ := dial(t, echo(&engine.WebSocketConfig{ReadLimit: 8}, errs), websocketRequest("/ws"))
+			go tt.send(c)
+			c.expectClose(tt.code)
+			if err := <-errs; err == nil {
+				t.Error("ReadMessage didn't fail")
+			}
+		})
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := engine.NewHub()
+	joined := make(chan struct{}, 2)
+	errs := make(chan error, 2)
+	handler := engine.WebSocketHandler(&engine.WebSocketConfig{Hub: hub}, func(c *engine.WebSocketConn) {
+		if room := c.Request().URL.Query().Get("room"); room != "" {
+			hub.Join(room, c)
+		}
+		joined <- struct{}{}
+		_, _, err := c.ReadMessage()
+		errs <- err
+	})
+
+	lobby, _ := dial(t, handler, websocketRequest("/ws?room=lobby"))
+	idle, _ := dial(t, handler, websocketRequest("/ws"))
+	<-joined
+	<-joined
+	if rooms := hub.Rooms(); len(rooms) != 1 || rooms[0] != "lobby" {
+		t.Errorf("Rooms = %v, want [lobby]", rooms)
+	}
+	if n := len(hub.Presence("lobby")); n != 1 {
+		t.Errorf("Presence = %d connections, want 1", n)
+	}
+	go hub.BroadcastJSON("lobby", engine.J{"msg": "hi"})
+	lobby.expectMessage(1, `{"msg":"hi"}`)
+
+	// Connections are closed on shutdown whether or not they joined a room.
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- hub.Shutdown(ctx)
+	}()
+	for _, c := range []*wsClient{lobby, idle} {
+		c.expectClose(engine.CloseGoingAway)
+		c.sendClose(engine.CloseGoingAway, "")
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := (<-errs).(*engine.CloseError); !ok {
+			t.Error("ReadMessage didn't return a CloseError")
+		}
+	}
+	if rooms := hub.Rooms(); len(rooms) != 0 {
+		t.Errorf("Rooms after shutdown = %v", rooms)
+	}
+
+	tc := enginetest.New(t, handler)
+	tc.Get("/ws").
+		Header("Connection", "Upgrade").
+		Header("Upgrade", "websocket").
+		Header("Sec-WebSocket-Version", "13").
+		Header("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==").
+		Do().AssertStatus(http.StatusServiceUnavailable)
+}
+
+func TestHubShutdownTimeout(t *testing.T) {
+	hub := engine.NewHub()
+	ready := make(chan struct{})
+	handler := engine.WebSocketHandler(&engine.WebSocketConfig{Hub: hub}, func(c *engine.WebSocketConn) {
+		close(ready)
+		c.ReadMessage()
+	})
+	c, _ := dial(t, handler, websocketRequest("/ws"))
+	<-ready
+
+	// The client never acknowledges, so the connection is dropped.
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		shutdown <- hub.Shutdown(ctx)
+	}()
+	c.expectClose(engine.CloseGoingAway)
+	if err := <-shutdown; err != context.DeadlineExceeded {
+		t.Errorf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := c.br.ReadByte(); err != io.EOF {
+		t.Errorf("read after shutdown = %v, want io.EOF", err)
+	}
+}